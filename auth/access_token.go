@@ -2,18 +2,70 @@ package auth
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"log/slog"
+	"time"
 
+	"github.com/redis/go-redis/v9"
 	"github.com/securemcp/securemcp-okta-gateway/logging"
 	"github.com/securemcp/securemcp-okta-gateway/util"
 )
 
-func (a *Auth) GenerateAccessToken(ctx context.Context, uid string) (string, *AuthError) {
+func (a *Auth) GenerateAccessToken(ctx context.Context, record *TokenRecord) (string, *AuthError) {
 	log := logging.FromContext(ctx).With(
 		slog.String("auth", "GenerateAccessToken"),
 	)
+	lifetime := record.Lifetime()
+	if lifetime < time.Second {
+		log.Error("Access token would expire immediately", "uid", record.UID, "expires_at", record.ExpiresAt)
+		return "", &AuthError{
+			AuthJsonError: AuthJsonError{
+				Code:        InvalidGrant,
+				Description: "the token would expire immediately",
+			},
+		}
+	}
+	now := time.Now()
+	record = &TokenRecord{
+		UID:       record.UID,
+		Email:     record.Email,
+		Groups:    record.Groups,
+		Amr:       record.Amr,
+		ClientID:  record.ClientID,
+		Scopes:    record.Scopes,
+		Resource:  record.Resource,
+		Act:       record.Act,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(lifetime).Unix(),
+	}
+
+	if a.accessTokenFormat == AccessTokenFormatJWT {
+		accessToken, err := a.signAccessToken(record)
+		if err != nil {
+			log.Error("Failed to sign access token", "error", err)
+			return "", &AuthError{
+				AuthJsonError: AuthJsonError{
+					Code:        ServerError,
+					Description: "Failed to generate access token",
+				},
+			}
+		}
+		log.Info("Generated access token", "uid", record.UID, "client_id", record.ClientID, "format", a.accessTokenFormat)
+		return accessToken, nil
+	}
+
 	accessToken := util.RandString(32)
-	if err := a.accessTokenKVS.Set(ctx, accessToken, uid); err != nil {
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return "", &AuthError{
+			AuthJsonError: AuthJsonError{
+				Code:        ServerError,
+				Description: "Failed to marshal access token",
+			},
+		}
+	}
+	if err := a.accessTokenKVS.SetEX(ctx, accessToken, recordJSON, lifetime); err != nil {
 		return "", &AuthError{
 			AuthJsonError: AuthJsonError{
 				Code:        ServerError,
@@ -21,14 +73,29 @@ func (a *Auth) GenerateAccessToken(ctx context.Context, uid string) (string, *Au
 			},
 		}
 	}
-	log.Info("Generated access token", "uid", uid)
+	log.Info("Generated access token", "uid", record.UID, "client_id", record.ClientID, "format", a.accessTokenFormat)
 	return accessToken, nil
 }
 
-func (a *Auth) VerifyAccessToken(ctx context.Context, accessToken string) (string, error) {
-	uid, err := a.accessTokenKVS.Get(ctx, accessToken)
-	if err != nil {
-		return "", err
+func (a *Auth) VerifyAccessToken(ctx context.Context, accessToken string) (*TokenRecord, error) {
+	if isJWT(accessToken) {
+		claims, err := a.parseAccessToken(accessToken)
+		if err != nil {
+			return nil, err
+		}
+		// The cache keeps Redis off the path of every request
+		if revoked, err := a.revocations.revoked(ctx, claims.ID); err != nil {
+			return nil, err
+		} else if revoked {
+			return nil, ErrInvalidAccessToken
+		}
+		return a.claimsRecord(claims), nil
+	}
+
+	record, err := getTokenRecord(ctx, a.accessTokenKVS, accessToken)
+	if errors.Is(err, redis.Nil) {
+		// Expired opaque tokens are evicted from the kvs and look unknown
+		return nil, ErrInvalidAccessToken
 	}
-	return uid, nil
+	return record, err
 }
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"slices"
+	"time"
+
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/securemcp/securemcp-okta-gateway/util"
+)
+
+// IdentityAssertionType is the typ header of identity assertions. It keeps
+// assertions from being accepted as access tokens.
+const IdentityAssertionType = "identity+jwt"
+
+// SignIdentityAssertion signs a short-lived JWT telling a backend who the
+// caller is. iss, sub, aud, iat, exp and jti are always set, claims selects
+// from email, groups, amr, client_id and scope.
+func (a *Auth) SignIdentityAssertion(record *TokenRecord, audience string, claims []string, ttl time.Duration) (string, error) {
+	signer, err := a.keys.SigningKey().Signer(IdentityAssertionType)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	registered := jwt.Claims{
+		Issuer:    a.baseURL,
+		Subject:   record.UID,
+		Audience:  jwt.Audience{audience},
+		Expiry:    jwt.NewNumericDate(now.Add(ttl)),
+		IssuedAt:  jwt.NewNumericDate(now),
+		NotBefore: jwt.NewNumericDate(now),
+		ID:        util.RandString(16),
+	}
+	extra := map[string]any{}
+	if slices.Contains(claims, "email") && record.Email != "" {
+		extra["email"] = record.Email
+	}
+	if slices.Contains(claims, "groups") {
+		extra["groups"] = nonNilStrings(record.Groups)
+	}
+	if slices.Contains(claims, "amr") && len(record.Amr) > 0 {
+		extra["amr"] = record.Amr
+	}
+	if slices.Contains(claims, "client_id") {
+		extra["client_id"] = record.ClientID
+	}
+	if slices.Contains(claims, "scope") && len(record.Scopes) > 0 {
+		extra["scope"] = record.Scope()
+	}
+	return jwt.Signed(signer).Claims(registered).Claims(extra).Serialize()
+}
+
+func nonNilStrings(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
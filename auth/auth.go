@@ -2,44 +2,83 @@ package auth
 
 import (
 	"github.com/redis/go-redis/v9"
+	"github.com/securemcp/securemcp-okta-gateway/keys"
 	"github.com/securemcp/securemcp-okta-gateway/kvs"
 )
 
+type AuthConfig struct {
+	BaseURL           string
+	AccessTokenFormat string
+	Keys              *keys.Manager     // nil when the gateway signs nothing
+	ResourceServers   map[string]string // key: client_id, value: client_secret
+	ScopesSupported   []string
+	DefaultScopes     []string // granted when the client does not request scope
+	Resources         []string // canonical resource of every proxy
+	TokenExchange     []*TokenExchangeRule
+}
+
 type Auth struct {
 	baseURL                           string
+	accessTokenFormat                 string
+	keys                              *keys.Manager
+	resourceServers                   map[string]string
 	clientKVS                         *kvs.KVS // key: client_id, value: client
 	codeKVS                           *kvs.KVS // key: code, value: code
 	authorizationKVS                  *kvs.KVS // key: sid, value: authorization param
 	accessTokenKVS                    *kvs.KVS // key: access_token, value: access_token
-	refreshTokenKVS                   *kvs.KVS // key: refresh_token, value: refresh_token
+	refreshTokenKVS                   *kvs.KVS // key: refresh_token, value: refresh token data
+	usedRefreshTokenKVS               *kvs.KVS // key: rotated refresh_token, value: refresh token data
+	refreshTokenFamilyKVS             *kvs.KVS // key: family_id, value: current refresh_token
+	revokedTokenKVS                   *kvs.KVS // key: jti, value: revoked jwt access token
+	revocations                       *revocationCache
 	supportedTokenEndpointAuthMethods map[string]bool
 	supportedGrantTypes               map[string]bool
 	supportedResponseTypes            map[string]bool
 	supportedCodeChallengeMethods     map[string]bool
+	supportedScopes                   map[string]bool
+	defaultScopes                     []string
+	supportedResources                []string
+	tokenExchangeRules                []*TokenExchangeRule
 }
 
-func NewAuth(baseURL string, rdb *redis.Client) *Auth {
+func NewAuth(config *AuthConfig, rdb *redis.Client) *Auth {
 	clientKVS := kvs.NewKVS(rdb, "client", kvs.OAuthClientTTL)
 	codeKVS := kvs.NewKVS(rdb, "code", kvs.OAuthStateTTL)
 	authorizationKVS := kvs.NewKVS(rdb, "authorization", kvs.OAuthStateTTL)
 	accessTokenKVS := kvs.NewKVS(rdb, "access_token", kvs.OAuthAccessTokenTTL)
 	refreshTokenKVS := kvs.NewKVS(rdb, "refresh_token", kvs.OAuthRefreshTokenTTL)
+	usedRefreshTokenKVS := kvs.NewKVS(rdb, "used_refresh_token", kvs.OAuthRefreshTokenTTL)
+	refreshTokenFamilyKVS := kvs.NewKVS(rdb, "refresh_token_family", kvs.OAuthRefreshTokenTTL)
+	revokedTokenKVS := kvs.NewKVS(rdb, "revoked_token", kvs.OAuthAccessTokenTTL)
+
+	supportedScopes := map[string]bool{}
+	for _, s := range config.ScopesSupported {
+		supportedScopes[s] = true
+	}
 
 	return &Auth{
-		baseURL:          baseURL,
-		clientKVS:        clientKVS,
-		codeKVS:          codeKVS,
-		authorizationKVS: authorizationKVS,
-		accessTokenKVS:   accessTokenKVS,
-		refreshTokenKVS:  refreshTokenKVS,
+		baseURL:               config.BaseURL,
+		accessTokenFormat:     config.AccessTokenFormat,
+		keys:                  config.Keys,
+		resourceServers:       config.ResourceServers,
+		clientKVS:             clientKVS,
+		codeKVS:               codeKVS,
+		authorizationKVS:      authorizationKVS,
+		accessTokenKVS:        accessTokenKVS,
+		refreshTokenKVS:       refreshTokenKVS,
+		usedRefreshTokenKVS:   usedRefreshTokenKVS,
+		refreshTokenFamilyKVS: refreshTokenFamilyKVS,
+		revokedTokenKVS:       revokedTokenKVS,
+		revocations:           newRevocationCache(revokedTokenKVS),
 		supportedTokenEndpointAuthMethods: map[string]bool{
 			"client_secret_basic": true,
 			"client_secret_post":  true,
 			"none":                true,
 		},
 		supportedGrantTypes: map[string]bool{
-			"authorization_code": true,
-			"refresh_token":      true,
+			"authorization_code":   true,
+			"refresh_token":        true,
+			GrantTypeTokenExchange: true,
 		},
 		supportedResponseTypes: map[string]bool{
 			"code": true,
@@ -47,5 +86,9 @@ func NewAuth(baseURL string, rdb *redis.Client) *Auth {
 		supportedCodeChallengeMethods: map[string]bool{
 			"S256": true,
 		},
+		supportedScopes:    supportedScopes,
+		defaultScopes:      config.DefaultScopes,
+		supportedResources: config.Resources,
+		tokenExchangeRules: config.TokenExchange,
 	}
 }
@@ -9,9 +9,14 @@ import (
 
 type AuthorizationCodeParams struct {
 	UID           string
+	Email         string
+	Groups        []string
+	Amr           []string
 	ClientID      string
 	RedirectURI   string
 	CodeChallenge string
+	Scopes        []string
+	Resource      string
 }
 
 func (a *Auth) GenerateAuthorizationCode(ctx context.Context, params *AuthorizationCodeParams) (string, *AuthError) {
@@ -37,10 +42,10 @@ func (a *Auth) GenerateAuthorizationCode(ctx context.Context, params *Authorizat
 	return code, nil
 }
 
-func (a *Auth) VerifyAuthorizationCode(ctx context.Context, code, clientID, redirectURI, codeVerifier string) (string, *AuthError) {
+func (a *Auth) VerifyAuthorizationCode(ctx context.Context, code, clientID, redirectURI, codeVerifier string) (*AuthorizationCodeParams, *AuthError) {
 	storedCodeDataJSON, err := a.codeKVS.GetDel(ctx, code)
 	if err != nil {
-		return "", &AuthError{
+		return nil, &AuthError{
 			AuthJsonError: AuthJsonError{
 				Code:        ServerError,
 				Description: "Failed to get authorization code",
@@ -50,7 +55,7 @@ func (a *Auth) VerifyAuthorizationCode(ctx context.Context, code, clientID, redi
 
 	var storedCodeData AuthorizationCodeParams
 	if err := json.Unmarshal([]byte(storedCodeDataJSON), &storedCodeData); err != nil {
-		return "", &AuthError{
+		return nil, &AuthError{
 			AuthJsonError: AuthJsonError{
 				Code:        ServerError,
 				Description: "Failed to unmarshal authorization code",
@@ -59,7 +64,7 @@ func (a *Auth) VerifyAuthorizationCode(ctx context.Context, code, clientID, redi
 	}
 
 	if storedCodeData.ClientID != clientID || storedCodeData.RedirectURI != redirectURI {
-		return "", &AuthError{
+		return nil, &AuthError{
 			AuthJsonError: AuthJsonError{
 				Code:        InvalidRequest,
 				Description: "Invalid client or redirect URI",
@@ -69,7 +74,7 @@ func (a *Auth) VerifyAuthorizationCode(ctx context.Context, code, clientID, redi
 
 	hash := util.S256(codeVerifier)
 	if hash != storedCodeData.CodeChallenge {
-		return "", &AuthError{
+		return nil, &AuthError{
 			AuthJsonError: AuthJsonError{
 				Code:        InvalidRequest,
 				Description: "Invalid code challenge",
@@ -77,5 +82,5 @@ func (a *Auth) VerifyAuthorizationCode(ctx context.Context, code, clientID, redi
 		}
 	}
 
-	return storedCodeData.UID, nil
+	return &storedCodeData, nil
 }
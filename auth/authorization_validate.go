@@ -15,6 +15,8 @@ type AuthorizationParams struct {
 	State               string
 	CodeChallenge       string
 	CodeChallengeMethod string
+	Scope               string
+	Resource            string
 }
 
 func (a *Auth) ValidateAuthorizationClient(ctx context.Context, params *AuthorizationParams, client *Client) *AuthError {
@@ -68,6 +70,34 @@ func (a *Auth) ValidateAuthorizationParams(ctx context.Context, params *Authoriz
 		}
 	}
 
+	scopes, ok := a.resolveScopes(params.Scope)
+	if !ok {
+		return &AuthError{
+			AuthRedirectError: AuthRedirectError{
+				RedirectURI:      params.RedirectURI,
+				ErrorCode:        InvalidScope,
+				ErrorDescription: "scope must be one or more of " + strings.Join(a.GetSupportScopes(), ", "),
+				State:            params.State,
+			},
+		}
+	}
+	params.Scope = strings.Join(scopes, " ")
+
+	if params.Resource != "" {
+		resource, ok := a.resolveResource(params.Resource)
+		if !ok {
+			return &AuthError{
+				AuthRedirectError: AuthRedirectError{
+					RedirectURI:      params.RedirectURI,
+					ErrorCode:        InvalidTarget,
+					ErrorDescription: "resource is not served by this gateway",
+					State:            params.State,
+				},
+			}
+		}
+		params.Resource = resource
+	}
+
 	if !util.IsValidCodeChallengeOrVerifier(params.CodeChallenge) {
 		return &AuthError{
 			AuthRedirectError: AuthRedirectError{
@@ -3,6 +3,10 @@ package auth
 const (
 	InvalidClientMetadata = "invalid_client_metadata"
 	InvalidRequest        = "invalid_request"
+	InvalidClient         = "invalid_client"
+	InvalidGrant          = "invalid_grant"
+	InvalidScope          = "invalid_scope"
+	InvalidTarget         = "invalid_target"
 	UnauthorizedClient    = "unauthorized_client"
 	ServerError           = "server_error"
 )
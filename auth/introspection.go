@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/securemcp/securemcp-okta-gateway/kvs"
+	"github.com/securemcp/securemcp-okta-gateway/logging"
+)
+
+type IntrospectionRequestParams struct {
+	Token         string
+	TokenTypeHint string
+	Authorization string
+}
+
+type IntrospectionResponse struct {
+	Active    bool   `json:"active"`
+	Sub       string `json:"sub,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Aud       string `json:"aud,omitempty"`
+	Iss       string `json:"iss,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Act       *Actor `json:"act,omitempty"`
+}
+
+// AuthenticateResourceServer checks the HTTP Basic credentials of a backend
+// resource server against the configured resource server credentials.
+func (a *Auth) AuthenticateResourceServer(ctx context.Context, authorization string) (string, *AuthError) {
+	invalid := &AuthError{
+		AuthJsonError: AuthJsonError{
+			Code:        InvalidClient,
+			Description: "invalid resource server credentials",
+		},
+	}
+	if !strings.HasPrefix(authorization, "Basic ") {
+		return "", invalid
+	}
+	payload, err := base64.StdEncoding.DecodeString(authorization[len("Basic "):])
+	if err != nil {
+		return "", invalid
+	}
+	id, secret, ok := strings.Cut(string(payload), ":")
+	if !ok {
+		return "", invalid
+	}
+	expected, ok := a.resourceServers[id]
+	if !ok || subtle.ConstantTimeCompare([]byte(secret), []byte(expected)) != 1 {
+		return "", invalid
+	}
+	return id, nil
+}
+
+func (a *Auth) IntrospectionValidateParams(ctx context.Context, params *IntrospectionRequestParams) *AuthError {
+	if params.Token == "" {
+		return &AuthError{
+			AuthJsonError: AuthJsonError{
+				Code:        InvalidRequest,
+				Description: "token is required",
+			},
+		}
+	}
+	return nil
+}
+
+// IntrospectToken implements RFC 7662. Tokens that are unknown, expired or
+// revoked are reported as inactive.
+func (a *Auth) IntrospectToken(ctx context.Context, params *IntrospectionRequestParams) (*IntrospectionResponse, *AuthError) {
+	log := logging.FromContext(ctx).With(
+		slog.String("auth", "IntrospectToken"),
+	)
+
+	introspectors := []func(context.Context, string) (*IntrospectionResponse, error){a.introspectAccessToken, a.introspectRefreshToken}
+	if params.TokenTypeHint == "refresh_token" {
+		introspectors = []func(context.Context, string) (*IntrospectionResponse, error){a.introspectRefreshToken, a.introspectAccessToken}
+	}
+	for _, introspect := range introspectors {
+		resp, err := introspect(ctx, params.Token)
+		if err != nil {
+			log.Error("Failed to introspect token", "error", err)
+			return nil, &AuthError{
+				AuthJsonError: AuthJsonError{
+					Code:        ServerError,
+					Description: "Failed to introspect token",
+				},
+			}
+		}
+		if resp != nil {
+			return resp, nil
+		}
+	}
+	return &IntrospectionResponse{Active: false}, nil
+}
+
+func (a *Auth) introspectAccessToken(ctx context.Context, token string) (*IntrospectionResponse, error) {
+	if isJWT(token) {
+		record, exp, err := a.activeJWTAccessToken(ctx, token)
+		if record == nil || err != nil {
+			return nil, err
+		}
+		return a.introspectionResponse(ctx, record, exp, "Bearer")
+	}
+	return a.introspectStoredToken(ctx, a.accessTokenKVS, token, "Bearer")
+}
+
+// activeJWTAccessToken returns the record of a JWT access token that is
+// neither expired nor revoked, and nil otherwise.
+func (a *Auth) activeJWTAccessToken(ctx context.Context, token string) (*TokenRecord, time.Time, error) {
+	claims, err := a.parseAccessToken(token)
+	if err != nil {
+		return nil, time.Time{}, nil
+	}
+	if _, err := a.revokedTokenKVS.Get(ctx, claims.ID); err == nil {
+		return nil, time.Time{}, nil
+	} else if !errors.Is(err, redis.Nil) {
+		return nil, time.Time{}, err
+	}
+	return a.claimsRecord(claims), claims.Expiry.Time(), nil
+}
+
+func (a *Auth) introspectRefreshToken(ctx context.Context, token string) (*IntrospectionResponse, error) {
+	return a.introspectStoredToken(ctx, a.refreshTokenKVS, token, "")
+}
+
+func (a *Auth) introspectStoredToken(ctx context.Context, store *kvs.KVS, token, tokenType string) (*IntrospectionResponse, error) {
+	record, err := getTokenRecord(ctx, store, token)
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ttl, err := store.TTL(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return a.introspectionResponse(ctx, record, time.Now().Add(ttl), tokenType)
+}
+
+// introspectionResponse reports a token as active only while the client it
+// is bound to is still registered.
+func (a *Auth) introspectionResponse(ctx context.Context, record *TokenRecord, exp time.Time, tokenType string) (*IntrospectionResponse, error) {
+	if ok, err := a.clientRegistered(ctx, record.ClientID); !ok || err != nil {
+		return nil, err
+	}
+	aud := record.Resource
+	if aud == "" {
+		aud = a.baseURL
+	}
+	return &IntrospectionResponse{
+		Active:    true,
+		Sub:       record.UID,
+		ClientID:  record.ClientID,
+		Scope:     record.Scope(),
+		Exp:       exp.Unix(),
+		Iat:       record.IssuedAt,
+		Aud:       aud,
+		Iss:       a.baseURL,
+		TokenType: tokenType,
+		Act:       record.Act,
+	}, nil
+}
+
+func (a *Auth) clientRegistered(ctx context.Context, clientID string) (bool, error) {
+	if _, err := a.clientKVS.Get(ctx, clientID); err != nil {
+		if errors.Is(err, redis.Nil) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
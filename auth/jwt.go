@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/securemcp/securemcp-okta-gateway/util"
+)
+
+const (
+	AccessTokenFormatOpaque = "opaque"
+	AccessTokenFormatJWT    = "jwt"
+)
+
+// RFC 9068 typ header of JWT access tokens
+const accessTokenType = "at+jwt"
+
+var (
+	ErrInvalidAccessToken = errors.New("invalid access token")
+	ErrExpiredAccessToken = errors.New("expired access token")
+)
+
+type AccessTokenClaims struct {
+	jwt.Claims
+	ClientID string   `json:"client_id"`
+	Scope    string   `json:"scope,omitempty"`
+	Email    string   `json:"email,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+	Amr      []string `json:"amr,omitempty"`
+	Act      *Actor   `json:"act,omitempty"`
+}
+
+func (a *Auth) claimsRecord(c *AccessTokenClaims) *TokenRecord {
+	record := &TokenRecord{
+		UID:      c.Subject,
+		Email:    c.Email,
+		Groups:   c.Groups,
+		Amr:      c.Amr,
+		Act:      c.Act,
+		ClientID: c.ClientID,
+		Scopes:   strings.Fields(c.Scope),
+		IssuedAt: c.IssuedAt.Time().Unix(),
+	}
+	if len(c.Audience) == 1 && c.Audience[0] != a.baseURL {
+		record.Resource = c.Audience[0]
+	}
+	return record
+}
+
+func (a *Auth) signAccessToken(record *TokenRecord) (string, error) {
+	signer, err := a.keys.SigningKey().Signer(accessTokenType)
+	if err != nil {
+		return "", err
+	}
+	issuedAt := time.Unix(record.IssuedAt, 0)
+	audience := a.baseURL
+	if record.Resource != "" {
+		audience = record.Resource
+	}
+	claims := AccessTokenClaims{
+		Claims: jwt.Claims{
+			Issuer:    a.baseURL,
+			Subject:   record.UID,
+			Audience:  jwt.Audience{audience},
+			Expiry:    jwt.NewNumericDate(time.Unix(record.ExpiresAt, 0)),
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+			NotBefore: jwt.NewNumericDate(issuedAt),
+			ID:        util.RandString(16),
+		},
+		ClientID: record.ClientID,
+		Scope:    record.Scope(),
+		Email:    record.Email,
+		Groups:   record.Groups,
+		Amr:      record.Amr,
+		Act:      record.Act,
+	}
+	return jwt.Signed(signer).Claims(claims).Serialize()
+}
+
+func (a *Auth) parseAccessToken(accessToken string) (*AccessTokenClaims, error) {
+	if a.keys == nil {
+		return nil, ErrInvalidAccessToken
+	}
+	token, err := jwt.ParseSigned(accessToken, a.keys.Algorithms())
+	if err != nil {
+		return nil, ErrInvalidAccessToken
+	}
+	if len(token.Headers) != 1 {
+		return nil, ErrInvalidAccessToken
+	}
+	// Other JWTs signed by the gateway, such as identity assertions, are not access tokens
+	if typ, _ := token.Headers[0].ExtraHeaders[jose.HeaderType].(string); typ != accessTokenType {
+		return nil, ErrInvalidAccessToken
+	}
+	key, ok := a.keys.VerificationKey(token.Headers[0].KeyID)
+	if !ok {
+		return nil, ErrInvalidAccessToken
+	}
+	publicKey := key.Public()
+	var claims AccessTokenClaims
+	if err := token.Claims(publicKey.Key, &claims); err != nil {
+		return nil, ErrInvalidAccessToken
+	}
+	if err := claims.ValidateWithLeeway(jwt.Expected{
+		Issuer:      a.baseURL,
+		AnyAudience: jwt.Audience(a.audiences()),
+		Time:        time.Now(),
+	}, 0); err != nil {
+		if errors.Is(err, jwt.ErrExpired) {
+			return nil, ErrExpiredAccessToken
+		}
+		return nil, ErrInvalidAccessToken
+	}
+	return &claims, nil
+}
+
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
@@ -1,5 +1,7 @@
 package auth
 
+import "github.com/go-jose/go-jose/v4"
+
 func (a *Auth) GetBaseURL() string {
 	return a.baseURL
 }
@@ -16,6 +18,25 @@ func (a *Auth) GetTokenURL() string {
 	return a.baseURL + "/auth/token"
 }
 
+func (a *Auth) GetRevocationURL() string {
+	return a.baseURL + "/auth/revoke"
+}
+
+func (a *Auth) GetIntrospectionURL() string {
+	return a.baseURL + "/auth/introspect"
+}
+
+func (a *Auth) GetJWKSURL() string {
+	return a.baseURL + "/.well-known/jwks.json"
+}
+
+func (a *Auth) GetJWKS() jose.JSONWebKeySet {
+	if a.keys == nil {
+		return jose.JSONWebKeySet{Keys: []jose.JSONWebKey{}}
+	}
+	return a.keys.JWKS()
+}
+
 func (a *Auth) GetSupportTokenEndpointAuthMethods() []string {
 	methods := make([]string, 0, len(a.supportedTokenEndpointAuthMethods))
 	for m := range a.supportedTokenEndpointAuthMethods {
@@ -43,6 +64,15 @@ func (a *Auth) GetSupportResponseTypes() []string {
 	return types
 }
 
+func (a *Auth) GetSupportScopes() []string {
+	scopes := make([]string, 0, len(a.supportedScopes))
+	for s := range a.supportedScopes {
+		scopes = append(scopes, s)
+	}
+
+	return scopes
+}
+
 func (a *Auth) GetSupportCodeChallengeMethods() []string {
 	methods := make([]string, 0, len(a.supportedCodeChallengeMethods))
 	for m := range a.supportedCodeChallengeMethods {
@@ -2,18 +2,34 @@ package auth
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"log/slog"
+	"time"
 
+	"github.com/redis/go-redis/v9"
 	"github.com/securemcp/securemcp-okta-gateway/logging"
 	"github.com/securemcp/securemcp-okta-gateway/util"
 )
 
-func (a *Auth) GenerateRefreshToken(ctx context.Context, uid string) (string, *AuthError) {
+func (a *Auth) GenerateRefreshToken(ctx context.Context, record *TokenRecord) (string, *AuthError) {
 	log := logging.FromContext(ctx).With(
 		slog.String("auth", "GenerateRefreshToken"),
 	)
-	refreshToken := util.RandString(32)
-	if err := a.refreshTokenKVS.Set(ctx, refreshToken, uid); err != nil {
+	// Every authorization starts a new family, rotated tokens inherit it
+	record = &TokenRecord{
+		UID:      record.UID,
+		Email:    record.Email,
+		Groups:   record.Groups,
+		Amr:      record.Amr,
+		ClientID: record.ClientID,
+		Scopes:   record.Scopes,
+		Resource: record.Resource,
+		FamilyID: util.RandString(16),
+	}
+	refreshToken, err := a.storeRefreshToken(ctx, record)
+	if err != nil {
+		log.Error("Failed to store refresh token", "error", err)
 		return "", &AuthError{
 			AuthJsonError: AuthJsonError{
 				Code:        ServerError,
@@ -21,19 +37,150 @@ func (a *Auth) GenerateRefreshToken(ctx context.Context, uid string) (string, *A
 			},
 		}
 	}
-	log.Info("Generated refresh token", "uid", uid)
+	log.Info("Generated refresh token", "uid", record.UID, "client_id", record.ClientID, "family_id", record.FamilyID)
 	return refreshToken, nil
 }
 
-func (a *Auth) VerifyRefreshToken(ctx context.Context, refreshToken string) (string, *AuthError) {
-	uid, err := a.refreshTokenKVS.Get(ctx, refreshToken)
+// RotateRefreshToken consumes refreshToken and issues its successor in the
+// same family. Presenting a refresh token that was already rotated, or one
+// that was issued to another client, revokes the whole family, since either
+// the client or an attacker holds a stolen copy.
+func (a *Auth) RotateRefreshToken(ctx context.Context, refreshToken, clientID string) (string, *TokenRecord, *AuthError) {
+	log := logging.FromContext(ctx).With(
+		slog.String("auth", "RotateRefreshToken"),
+	)
+	invalidGrant := &AuthError{
+		AuthJsonError: AuthJsonError{
+			Code:        InvalidGrant,
+			Description: "refresh_token is invalid",
+		},
+	}
+	serverError := &AuthError{
+		AuthJsonError: AuthJsonError{
+			Code:        ServerError,
+			Description: "Failed to rotate refresh token",
+		},
+	}
+
+	recordJSON, err := a.refreshTokenKVS.GetDel(ctx, refreshToken)
+	if errors.Is(err, redis.Nil) {
+		return "", nil, a.unknownRefreshToken(ctx, refreshToken, clientID)
+	}
 	if err != nil {
-		return "", &AuthError{
+		log.Error("Failed to get refresh token", "error", err)
+		return "", nil, serverError
+	}
+
+	var record TokenRecord
+	if err := json.Unmarshal([]byte(recordJSON), &record); err != nil {
+		log.Error("Failed to unmarshal refresh token", "error", err)
+		return "", nil, serverError
+	}
+	if record.ClientID != clientID {
+		log.Warn("Refresh token presented by another client, revoking token family",
+			slog.String("event", "security"),
+			slog.String("uid", record.UID),
+			slog.String("client_id", clientID),
+			slog.String("token_client_id", record.ClientID),
+			slog.String("family_id", record.FamilyID),
+		)
+		if err := a.revokeRefreshTokenFamily(ctx, record.FamilyID); err != nil {
+			log.Error("Failed to revoke refresh token family", "error", err)
+			return "", nil, serverError
+		}
+		return "", nil, invalidGrant
+	}
+	if _, err := a.refreshTokenFamilyKVS.Get(ctx, record.FamilyID); err != nil {
+		if errors.Is(err, redis.Nil) {
+			return "", nil, invalidGrant
+		}
+		log.Error("Failed to get refresh token family", "error", err)
+		return "", nil, serverError
+	}
+	if err := a.usedRefreshTokenKVS.Set(ctx, refreshToken, recordJSON); err != nil {
+		log.Error("Failed to mark refresh token as used", "error", err)
+		return "", nil, serverError
+	}
+
+	rotated, err := a.storeRefreshToken(ctx, &record)
+	if err != nil {
+		log.Error("Failed to store refresh token", "error", err)
+		return "", nil, serverError
+	}
+	log.Info("Rotated refresh token", "uid", record.UID, "client_id", record.ClientID, "family_id", record.FamilyID)
+	return rotated, &record, nil
+}
+
+// unknownRefreshToken rejects a refresh token that is not current. If it was
+// already rotated, its family is revoked.
+func (a *Auth) unknownRefreshToken(ctx context.Context, refreshToken, clientID string) *AuthError {
+	log := logging.FromContext(ctx).With(
+		slog.String("auth", "unknownRefreshToken"),
+	)
+	used, err := getTokenRecord(ctx, a.usedRefreshTokenKVS, refreshToken)
+	if errors.Is(err, redis.Nil) {
+		return &AuthError{
+			AuthJsonError: AuthJsonError{
+				Code:        InvalidGrant,
+				Description: "refresh_token is invalid",
+			},
+		}
+	}
+	if err != nil {
+		log.Error("Failed to get used refresh token", "error", err)
+		return &AuthError{
 			AuthJsonError: AuthJsonError{
 				Code:        ServerError,
-				Description: "Failed to verify refresh token",
+				Description: "Failed to rotate refresh token",
 			},
 		}
 	}
-	return uid, nil
+	log.Warn("Refresh token reuse detected, revoking token family",
+		slog.String("event", "security"),
+		slog.String("uid", used.UID),
+		slog.String("client_id", clientID),
+		slog.String("family_id", used.FamilyID),
+	)
+	if err := a.revokeRefreshTokenFamily(ctx, used.FamilyID); err != nil {
+		log.Error("Failed to revoke refresh token family", "error", err)
+		return &AuthError{
+			AuthJsonError: AuthJsonError{
+				Code:        ServerError,
+				Description: "Failed to rotate refresh token",
+			},
+		}
+	}
+	return &AuthError{
+		AuthJsonError: AuthJsonError{
+			Code:        InvalidGrant,
+			Description: "refresh_token is invalid",
+		},
+	}
+}
+
+func (a *Auth) storeRefreshToken(ctx context.Context, record *TokenRecord) (string, error) {
+	refreshToken := util.RandString(32)
+	record.IssuedAt = time.Now().Unix()
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	if err := a.refreshTokenKVS.Set(ctx, refreshToken, recordJSON); err != nil {
+		return "", err
+	}
+	if err := a.refreshTokenFamilyKVS.Set(ctx, record.FamilyID, refreshToken); err != nil {
+		return "", err
+	}
+	return refreshToken, nil
+}
+
+func (a *Auth) revokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	current, err := a.refreshTokenFamilyKVS.GetDel(ctx, familyID)
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return a.refreshTokenKVS.Del(ctx, current)
 }
@@ -47,7 +47,8 @@ func (a *Auth) RegisterValidate(ctx context.Context, metadata *ClientMetadata) (
 		metadata.GrantTypes = []string{"authorization_code"}
 	}
 	for _, gt := range metadata.GrantTypes {
-		if !a.supportedGrantTypes[gt] {
+		// Only resource servers exchange tokens, and they authenticate with their own credentials
+		if !a.supportedGrantTypes[gt] || gt == GrantTypeTokenExchange {
 			return nil, &AuthError{
 				AuthJsonError: AuthJsonError{
 					Code:        InvalidClientMetadata,
@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"net/url"
+	"strings"
+)
+
+// resolveResource maps an RFC 8707 resource indicator to the canonical
+// resource of the proxy it addresses. A client may send the proxy resource
+// itself or any URL below it, such as the MCP endpoint of the backend.
+func (a *Auth) resolveResource(resource string) (string, bool) {
+	u, err := url.Parse(resource)
+	if err != nil || !u.IsAbs() || u.Fragment != "" {
+		return "", false
+	}
+	for _, r := range a.supportedResources {
+		if resource == strings.TrimSuffix(r, "/") || strings.HasPrefix(resource, r) {
+			return r, true
+		}
+	}
+	return "", false
+}
+
+// ResolveTokenResource validates the resource parameter of a token request.
+// The resulting token is bound to the requested resource, which must match
+// the resource of the grant when the grant was already audience-restricted.
+func (a *Auth) ResolveTokenResource(requested, granted string) (string, *AuthError) {
+	if requested == "" {
+		return granted, nil
+	}
+	resolved, ok := a.resolveResource(requested)
+	if !ok || (granted != "" && resolved != granted) {
+		return "", &AuthError{
+			AuthJsonError: AuthJsonError{
+				Code:        InvalidTarget,
+				Description: "resource is invalid",
+			},
+		}
+	}
+	return resolved, nil
+}
+
+// audiences lists every audience a gateway access token may carry.
+func (a *Auth) audiences() []string {
+	audiences := append([]string{a.baseURL}, a.supportedResources...)
+	for _, rule := range a.tokenExchangeRules {
+		audiences = append(audiences, rule.Audience)
+	}
+	return audiences
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/securemcp/securemcp-okta-gateway/logging"
+)
+
+var errTokenClientMismatch = errors.New("token was issued to another client")
+
+type RevocationRequestParams struct {
+	Token         string
+	TokenTypeHint string
+	ClientID      string
+	ClientSecret  string
+	Authorization string
+}
+
+func (a *Auth) RevocationValidateParams(ctx context.Context, params *RevocationRequestParams) *AuthError {
+	if params.Token == "" {
+		return &AuthError{
+			AuthJsonError: AuthJsonError{
+				Code:        InvalidRequest,
+				Description: "token is required",
+			},
+		}
+	}
+	if params.Authorization != "" && params.ClientSecret != "" {
+		return &AuthError{
+			AuthJsonError: AuthJsonError{
+				Code:        InvalidRequest,
+				Description: "When Authorization header is present, client_secret form parameter must not be sent",
+			},
+		}
+	}
+	return nil
+}
+
+// AuthenticateClient resolves the client that sent a request to one of the
+// token management endpoints and checks its credentials the same way the
+// token endpoint does.
+func (a *Auth) AuthenticateClient(ctx context.Context, clientID, clientSecret, authorization string) (*Client, *AuthError) {
+	if strings.HasPrefix(authorization, "Basic ") {
+		payload, err := base64.StdEncoding.DecodeString(authorization[len("Basic "):])
+		if err == nil {
+			if id, _, ok := strings.Cut(string(payload), ":"); ok {
+				clientID = id
+			}
+		}
+	}
+	client, authErr := a.GetClient(ctx, clientID)
+	if authErr != nil {
+		return nil, authErr
+	}
+	if authErr := a.TokenValidateClientSecret(ctx, &TokenRequestParams{
+		ClientID:      clientID,
+		Authorization: authorization,
+		clientSecret:  clientSecret,
+	}, client); authErr != nil {
+		return nil, authErr
+	}
+	return client, nil
+}
+
+// RevokeToken implements RFC 7009. Unknown or already invalid tokens are not
+// an error. JWT access tokens are self-contained, so their jti is recorded as
+// revoked until they would have expired.
+func (a *Auth) RevokeToken(ctx context.Context, params *RevocationRequestParams, client *Client) *AuthError {
+	log := logging.FromContext(ctx).With(
+		slog.String("auth", "RevokeToken"),
+	)
+
+	revokers := []func(context.Context, string, *Client) (bool, error){a.revokeAccessToken, a.revokeRefreshToken}
+	if params.TokenTypeHint == "refresh_token" {
+		revokers = []func(context.Context, string, *Client) (bool, error){a.revokeRefreshToken, a.revokeAccessToken}
+	}
+	for _, revoke := range revokers {
+		revoked, err := revoke(ctx, params.Token, client)
+		if errors.Is(err, errTokenClientMismatch) {
+			log.Warn("Client tried to revoke a token issued to another client",
+				slog.String("event", "security"),
+				slog.String("client_id", client.ClientID),
+			)
+			return &AuthError{
+				AuthJsonError: AuthJsonError{
+					Code:        UnauthorizedClient,
+					Description: "token was not issued to this client",
+				},
+			}
+		}
+		if err != nil {
+			log.Error("Failed to revoke token", "error", err)
+			return &AuthError{
+				AuthJsonError: AuthJsonError{
+					Code:        ServerError,
+					Description: "Failed to revoke token",
+				},
+			}
+		}
+		if revoked {
+			log.Info("Revoked token", "client_id", client.ClientID)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (a *Auth) revokeAccessToken(ctx context.Context, token string, client *Client) (bool, error) {
+	if isJWT(token) {
+		claims, err := a.parseAccessToken(token)
+		if err != nil {
+			return false, nil
+		}
+		if claims.ClientID != client.ClientID {
+			return false, errTokenClientMismatch
+		}
+		return true, a.revocations.revoke(ctx, claims.ID, claims.Subject)
+	}
+	record, err := getTokenRecord(ctx, a.accessTokenKVS, token)
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if record.ClientID != client.ClientID {
+		return false, errTokenClientMismatch
+	}
+	return true, a.accessTokenKVS.Del(ctx, token)
+}
+
+func (a *Auth) revokeRefreshToken(ctx context.Context, token string, client *Client) (bool, error) {
+	record, err := getTokenRecord(ctx, a.refreshTokenKVS, token)
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if record.ClientID != client.ClientID {
+		return false, errTokenClientMismatch
+	}
+	return true, a.revokeRefreshTokenFamily(ctx, record.FamilyID)
+}
@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/securemcp/securemcp-okta-gateway/kvs"
+)
+
+// RevocationCacheTTL is how long a replica trusts what it last read about the
+// revocation of a JWT access token. A token revoked at another replica is
+// still accepted here for at most this long.
+const RevocationCacheTTL = 30 * time.Second
+
+// revocationCache keeps the revocation state of JWT access tokens in memory,
+// so that verifying them does not need Redis on every request.
+type revocationCache struct {
+	kvs *kvs.KVS // key: jti, value: revoked jwt access token
+
+	mu      sync.Mutex
+	entries map[string]revocationEntry // key: jti
+}
+
+type revocationEntry struct {
+	revoked   bool
+	checkedAt time.Time
+}
+
+func newRevocationCache(revokedTokenKVS *kvs.KVS) *revocationCache {
+	return &revocationCache{
+		kvs:     revokedTokenKVS,
+		entries: map[string]revocationEntry{},
+	}
+}
+
+// revoked reports whether the token with jti was revoked, reading Redis when
+// the cached state is older than RevocationCacheTTL.
+func (c *revocationCache) revoked(ctx context.Context, jti string) (bool, error) {
+	c.mu.Lock()
+	e, ok := c.entries[jti]
+	c.mu.Unlock()
+	if ok && (e.revoked || time.Since(e.checkedAt) < RevocationCacheTTL) {
+		return e.revoked, nil
+	}
+
+	_, err := c.kvs.Get(ctx, jti)
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return false, err
+	}
+	c.set(jti, err == nil)
+	return err == nil, nil
+}
+
+// revoke records the revocation in Redis and takes effect at once on this replica.
+func (c *revocationCache) revoke(ctx context.Context, jti, subject string) error {
+	if err := c.kvs.Set(ctx, jti, subject); err != nil {
+		return err
+	}
+	c.set(jti, true)
+	return nil
+}
+
+func (c *revocationCache) set(jti string, revoked bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	// Revoked tokens expire with the access token, the others are read again
+	if len(c.entries) >= 10000 {
+		for k, e := range c.entries {
+			if now.Sub(e.checkedAt) >= kvs.OAuthAccessTokenTTL || (!e.revoked && now.Sub(e.checkedAt) >= RevocationCacheTTL) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	c.entries[jti] = revocationEntry{revoked: revoked, checkedAt: now}
+}
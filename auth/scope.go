@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+)
+
+// resolveScopes parses a space separated scope parameter. An empty parameter
+// grants the default scopes; unknown scopes are rejected.
+func (a *Auth) resolveScopes(scope string) ([]string, bool) {
+	requested := strings.Fields(scope)
+	if len(requested) == 0 {
+		return a.defaultScopes, true
+	}
+	scopes := make([]string, 0, len(requested))
+	for _, s := range requested {
+		if !a.supportedScopes[s] {
+			return nil, false
+		}
+		if !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes, true
+}
+
+// narrowScopes parses a scope parameter sent on refresh. It may only request
+// a subset of the originally granted scopes; an empty parameter keeps them all.
+func narrowScopes(scope string, granted []string) ([]string, bool) {
+	requested := strings.Fields(scope)
+	if len(requested) == 0 {
+		return granted, true
+	}
+	scopes := make([]string, 0, len(requested))
+	for _, s := range requested {
+		if !slices.Contains(granted, s) {
+			return nil, false
+		}
+		if !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes, true
+}
+
+// HasScopes reports whether the token was granted every scope in required.
+func (t *TokenRecord) HasScopes(required []string) bool {
+	for _, s := range required {
+		if !slices.Contains(t.Scopes, s) {
+			return false
+		}
+	}
+	return true
+}
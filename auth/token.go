@@ -3,9 +3,13 @@ package auth
 import (
 	"context"
 	"encoding/base64"
+	"errors"
+	"log/slog"
 	"slices"
 	"strings"
 
+	"github.com/redis/go-redis/v9"
+	"github.com/securemcp/securemcp-okta-gateway/logging"
 	"github.com/securemcp/securemcp-okta-gateway/util"
 )
 
@@ -16,6 +20,7 @@ type TokenRequestParams struct {
 	ClientID      string
 	CodeVerifier  string
 	Authorization string
+	Resource      string
 	clientSecret  string
 }
 
@@ -131,6 +136,8 @@ type RefreshTokenRequestParams struct {
 	RefreshToken string
 	ClientID     string
 	ClientSecret string
+	Scope        string
+	Resource     string
 }
 
 func (a *Auth) RefreshTokenValidateParams(ctx context.Context, params *RefreshTokenRequestParams) *AuthError {
@@ -150,11 +157,11 @@ func (a *Auth) RefreshTokenValidateParams(ctx context.Context, params *RefreshTo
 			},
 		}
 	}
-	if params.ClientID == "" || params.ClientSecret == "" {
+	if params.ClientID == "" {
 		return &AuthError{
 			AuthJsonError: AuthJsonError{
 				Code:        InvalidRequest,
-				Description: "client_id and client_secret are required",
+				Description: "client_id is required",
 			},
 		}
 	}
@@ -170,7 +177,8 @@ func (a *Auth) RefreshTokenValidateClient(ctx context.Context, params *RefreshTo
 			},
 		}
 	}
-	if params.ClientSecret != client.ClientSecret {
+	// Public clients cannot authenticate, refresh token rotation protects them instead
+	if client.TokenEndpointAuthMethod != "none" && (params.ClientSecret == "" || params.ClientSecret != client.ClientSecret) {
 		return &AuthError{
 			AuthJsonError: AuthJsonError{
 				Code:        InvalidRequest,
@@ -180,3 +188,48 @@ func (a *Auth) RefreshTokenValidateClient(ctx context.Context, params *RefreshTo
 	}
 	return nil
 }
+
+// RefreshTokenValidateGrant checks the scope and resource requested on
+// refresh against the original grant before the refresh token is rotated, and
+// returns the record for the new access token. Replaying a rotated refresh
+// token revokes its family here, as in RotateRefreshToken.
+func (a *Auth) RefreshTokenValidateGrant(ctx context.Context, params *RefreshTokenRequestParams) (*TokenRecord, *AuthError) {
+	log := logging.FromContext(ctx).With(
+		slog.String("auth", "RefreshTokenValidateGrant"),
+	)
+	record, err := getTokenRecord(ctx, a.refreshTokenKVS, params.RefreshToken)
+	if errors.Is(err, redis.Nil) {
+		return nil, a.unknownRefreshToken(ctx, params.RefreshToken, params.ClientID)
+	}
+	if err != nil {
+		log.Error("Failed to get refresh token", "error", err)
+		return nil, &AuthError{
+			AuthJsonError: AuthJsonError{
+				Code:        ServerError,
+				Description: "Failed to get refresh token",
+			},
+		}
+	}
+	scopes, ok := narrowScopes(params.Scope, record.Scopes)
+	if !ok {
+		return nil, &AuthError{
+			AuthJsonError: AuthJsonError{
+				Code:        InvalidScope,
+				Description: "scope must not exceed the scope originally granted",
+			},
+		}
+	}
+	resource, authErr := a.ResolveTokenResource(params.Resource, record.Resource)
+	if authErr != nil {
+		return nil, authErr
+	}
+	return &TokenRecord{
+		UID:      record.UID,
+		Email:    record.Email,
+		Groups:   record.Groups,
+		Amr:      record.Amr,
+		ClientID: record.ClientID,
+		Scopes:   scopes,
+		Resource: resource,
+	}, nil
+}
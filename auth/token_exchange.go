@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/securemcp/securemcp-okta-gateway/logging"
+)
+
+const (
+	GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	TokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// TokenExchangeRule allows ResourceServers to exchange access tokens
+// presented to the proxy Resource for tokens for Audience.
+type TokenExchangeRule struct {
+	Resource        string
+	ResourceServers []string
+	Audience        string
+	Scopes          []string
+}
+
+type TokenExchangeRequestParams struct {
+	GrantType          string
+	SubjectToken       string
+	SubjectTokenType   string
+	RequestedTokenType string
+	Audience           string
+	Resource           string
+	Scope              string
+}
+
+// target is the audience of the exchanged token. Audience and resource both
+// name it, a request may send either or the same value in both.
+func (p *TokenExchangeRequestParams) target() string {
+	if p.Resource != "" {
+		return p.Resource
+	}
+	return p.Audience
+}
+
+func (a *Auth) TokenExchangeValidateParams(ctx context.Context, params *TokenExchangeRequestParams) *AuthError {
+	if params.GrantType != GrantTypeTokenExchange {
+		return &AuthError{
+			AuthJsonError: AuthJsonError{
+				Code:        InvalidRequest,
+				Description: "grant_type must be " + GrantTypeTokenExchange,
+			},
+		}
+	}
+	if params.SubjectToken == "" || params.SubjectTokenType != TokenTypeAccessToken {
+		return &AuthError{
+			AuthJsonError: AuthJsonError{
+				Code:        InvalidRequest,
+				Description: "subject_token is required and subject_token_type must be " + TokenTypeAccessToken,
+			},
+		}
+	}
+	if params.RequestedTokenType != "" && params.RequestedTokenType != TokenTypeAccessToken {
+		return &AuthError{
+			AuthJsonError: AuthJsonError{
+				Code:        InvalidRequest,
+				Description: "requested_token_type must be " + TokenTypeAccessToken,
+			},
+		}
+	}
+	if params.target() == "" || (params.Audience != "" && params.Resource != "" && params.Audience != params.Resource) {
+		return &AuthError{
+			AuthJsonError: AuthJsonError{
+				Code:        InvalidTarget,
+				Description: "exactly one audience or resource is required",
+			},
+		}
+	}
+	return nil
+}
+
+// ExchangeToken returns the record of a token for the requested audience on
+// behalf of the user of the subject token. The resource server that asked
+// for it is recorded as the actor, on top of any earlier actors. The token
+// carries no scope the subject token lacks and expires no later than it.
+func (a *Auth) ExchangeToken(ctx context.Context, params *TokenExchangeRequestParams, resourceServer string) (*TokenRecord, *AuthError) {
+	log := logging.FromContext(ctx).With(
+		slog.String("auth", "ExchangeToken"),
+	)
+	subject, exp, err := a.activeAccessToken(ctx, params.SubjectToken)
+	if err != nil {
+		log.Error("Failed to verify subject token", "error", err)
+		return nil, &AuthError{
+			AuthJsonError: AuthJsonError{
+				Code:        ServerError,
+				Description: "Failed to verify subject_token",
+			},
+		}
+	}
+	if subject == nil || time.Until(exp) < time.Second {
+		return nil, &AuthError{
+			AuthJsonError: AuthJsonError{
+				Code:        InvalidGrant,
+				Description: "subject_token is invalid",
+			},
+		}
+	}
+
+	target := params.target()
+	// Tokens issued without a resource indicator are valid for every proxy
+	i := slices.IndexFunc(a.tokenExchangeRules, func(rule *TokenExchangeRule) bool {
+		return rule.Audience == target &&
+			slices.Contains(rule.ResourceServers, resourceServer) &&
+			(subject.Resource == "" || subject.Resource == rule.Resource)
+	})
+	if i < 0 {
+		return nil, &AuthError{
+			AuthJsonError: AuthJsonError{
+				Code:        InvalidTarget,
+				Description: "token exchange to this audience is not allowed",
+			},
+		}
+	}
+	// A rule without scopes passes on those of the subject token
+	allowed := subject.Scopes
+	if rule := a.tokenExchangeRules[i]; len(rule.Scopes) > 0 {
+		allowed = slices.DeleteFunc(slices.Clone(subject.Scopes), func(s string) bool {
+			return !slices.Contains(rule.Scopes, s)
+		})
+	}
+	scopes, ok := narrowScopes(params.Scope, allowed)
+	if !ok {
+		return nil, &AuthError{
+			AuthJsonError: AuthJsonError{
+				Code:        InvalidScope,
+				Description: "scope is not allowed for this audience or not granted to subject_token",
+			},
+		}
+	}
+	return &TokenRecord{
+		UID:       subject.UID,
+		Email:     subject.Email,
+		Groups:    subject.Groups,
+		Amr:       subject.Amr,
+		ClientID:  subject.ClientID,
+		Scopes:    scopes,
+		Resource:  target,
+		Act:       &Actor{Sub: resourceServer, Act: subject.Act},
+		ExpiresAt: exp.Unix(),
+	}, nil
+}
+
+// activeAccessToken returns the record and expiry of an access token that is
+// neither expired nor revoked and whose client is still registered, and nil
+// otherwise.
+func (a *Auth) activeAccessToken(ctx context.Context, token string) (*TokenRecord, time.Time, error) {
+	var record *TokenRecord
+	var exp time.Time
+	var err error
+	if isJWT(token) {
+		record, exp, err = a.activeJWTAccessToken(ctx, token)
+	} else {
+		record, err = getTokenRecord(ctx, a.accessTokenKVS, token)
+		if errors.Is(err, redis.Nil) {
+			return nil, time.Time{}, nil
+		}
+		if err == nil {
+			var ttl time.Duration
+			ttl, err = a.accessTokenKVS.TTL(ctx, token)
+			// Negative when the token expired since it was read
+			if ttl < 0 {
+				record = nil
+			}
+			exp = time.Now().Add(ttl)
+		}
+	}
+	if record == nil || err != nil {
+		return nil, time.Time{}, err
+	}
+	if ok, err := a.clientRegistered(ctx, record.ClientID); !ok || err != nil {
+		return nil, time.Time{}, err
+	}
+	return record, exp, nil
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/securemcp/securemcp-okta-gateway/kvs"
+)
+
+// TokenRecord is what the gateway knows about an issued access or refresh
+// token. Opaque tokens store it in the kvs, JWT access tokens carry it as claims.
+type TokenRecord struct {
+	UID      string   `json:"uid"`
+	Email    string   `json:"email,omitempty"`
+	Groups   []string `json:"groups,omitempty"`
+	Amr      []string `json:"amr,omitempty"`
+	ClientID string   `json:"client_id"`
+	Scopes   []string `json:"scopes,omitempty"`
+	Resource string   `json:"resource,omitempty"`
+	Act      *Actor   `json:"act,omitempty"` // exchanged tokens only
+	IssuedAt int64    `json:"issued_at"`
+	FamilyID string   `json:"family_id,omitempty"` // refresh tokens only
+	// ExpiresAt caps the lifetime of exchanged tokens at the subject token
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+// Actor is the RFC 8693 act claim. Act nests the previous actor when a token
+// was exchanged more than once.
+type Actor struct {
+	Sub string `json:"sub"`
+	Act *Actor `json:"act,omitempty"`
+}
+
+func (t *TokenRecord) Scope() string {
+	return strings.Join(t.Scopes, " ")
+}
+
+// Lifetime is how long an access token issued for the record is valid:
+// OAuthAccessTokenTTL, but no longer than until ExpiresAt.
+func (t *TokenRecord) Lifetime() time.Duration {
+	if t.ExpiresAt == 0 {
+		return kvs.OAuthAccessTokenTTL
+	}
+	return min(kvs.OAuthAccessTokenTTL, time.Until(time.Unix(t.ExpiresAt, 0)))
+}
+
+// InGroups reports whether the user belongs to any of the groups.
+func (t *TokenRecord) InGroups(groups []string) bool {
+	for _, g := range groups {
+		if slices.Contains(t.Groups, g) {
+			return true
+		}
+	}
+	return false
+}
+
+func getTokenRecord(ctx context.Context, store *kvs.KVS, token string) (*TokenRecord, error) {
+	recordJSON, err := store.Get(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	var record TokenRecord
+	if err := json.Unmarshal([]byte(recordJSON), &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/goccy/go-yaml"
 	"github.com/joho/godotenv"
@@ -13,7 +15,13 @@ import (
 
 type Config struct {
 	BaseConfig
+	TokenConfig
 	OAuthOktaConfig
+	OAuthOIDCConfig
+	// The provider configured by environment comes first, followed by the
+	// identity_providers of config.yaml
+	IdentityProviders []*IdentityProviderConfig `ignored:"true"`
+	Connections       []*ConnectionConfig       `ignored:"true"`
 }
 
 type BaseConfig struct {
@@ -21,21 +29,122 @@ type BaseConfig struct {
 	Port        string `default:"8080" envconfig:"PORT"`
 	KVSAddr     string `default:"localhost:6379" envconfig:"KVS_ADDR"`
 	KVSPassword string `envconfig:"KVS_PASSWORD"`
+	// Upstream identity provider: okta or oidc
+	OAuthProvider        string   `default:"okta" envconfig:"OAUTH_PROVIDER"`
+	OAuthProviderDomains []string `envconfig:"OAUTH_PROVIDER_DOMAINS"`
+	// Take the client IP from X-Forwarded-For, only behind a trusted reverse proxy
+	TrustForwardedFor bool `default:"false" envconfig:"TRUST_FORWARDED_FOR"`
+	// Number of trusted proxies in front of the gateway appending to X-Forwarded-For
+	TrustedProxyHops int `default:"1" envconfig:"TRUSTED_PROXY_HOPS"`
+}
+
+type TokenConfig struct {
+	AccessTokenFormat          string            `default:"opaque" envconfig:"ACCESS_TOKEN_FORMAT"`
+	SigningAlg                 string            `default:"RS256" envconfig:"SIGNING_ALG"`
+	SigningKeyFile             string            `envconfig:"SIGNING_KEY_FILE"`
+	SigningPreviousKeyFiles    []string          `envconfig:"SIGNING_PREVIOUS_KEY_FILES"`
+	SigningKeyRotationInterval time.Duration     `default:"720h" envconfig:"SIGNING_KEY_ROTATION_INTERVAL"`
+	ResourceServers            map[string]string `envconfig:"RESOURCE_SERVERS"`
+	// Base64 AES-256 key encrypting generated signing keys in Redis, unset stores them in plaintext
+	SigningKeysEncryptionKey string `envconfig:"SIGNING_KEYS_ENCRYPTION_KEY"`
+	// Granted when a client does not request scope, empty grants none
+	DefaultScopes []string `envconfig:"DEFAULT_SCOPES"`
+	// Base64 AES-256 key encrypting the tokens of the identity provider, unset disables storing them
+	UpstreamTokenKey string `envconfig:"UPSTREAM_TOKEN_KEY"`
 }
 
 type OAuthOktaConfig struct {
-	OktaURL          string `required:"true" envconfig:"OAUTH_OKTA_URL"`
-	OktaClientID     string `required:"true" envconfig:"OAUTH_OKTA_CLIENT_ID"`
-	OktaClientSecret string `required:"true" envconfig:"OAUTH_OKTA_CLIENT_SECRET"`
-	OktaRedirectURI  string `required:"true" envconfig:"OAUTH_OKTA_REDIRECT_URI"`
+	OktaURL          string `envconfig:"OAUTH_OKTA_URL"`
+	OktaClientID     string `envconfig:"OAUTH_OKTA_CLIENT_ID"`
+	OktaClientSecret string `envconfig:"OAUTH_OKTA_CLIENT_SECRET"`
+	OktaRedirectURI  string `envconfig:"OAUTH_OKTA_REDIRECT_URI"`
+	// ID of a custom authorization server, or "org" for the org authorization server
+	OktaAuthorizationServerID string   `default:"default" envconfig:"OAUTH_OKTA_AUTHORIZATION_SERVER_ID"`
+	OktaScopes                []string `default:"openid,profile,email" envconfig:"OAUTH_OKTA_SCOPES"`
+	// API token used to read a user's groups when the ID token has no groups claim
+	OktaAPIToken string `envconfig:"OAUTH_OKTA_API_TOKEN"`
+}
+
+// OAuthOIDCConfig configures any standards-compliant OpenID Connect issuer
+// such as Keycloak, Entra ID or Google.
+type OAuthOIDCConfig struct {
+	OIDCIssuerURL    string   `envconfig:"OAUTH_OIDC_ISSUER_URL"`
+	OIDCClientID     string   `envconfig:"OAUTH_OIDC_CLIENT_ID"`
+	OIDCClientSecret string   `envconfig:"OAUTH_OIDC_CLIENT_SECRET"`
+	OIDCRedirectURI  string   `envconfig:"OAUTH_OIDC_REDIRECT_URI"`
+	OIDCScopes       []string `default:"openid,profile,email" envconfig:"OAUTH_OIDC_SCOPES"`
 }
 
+const defaultHeartbeatInterval = 30 * time.Second
+
+const (
+	LoadBalancingRoundRobin       = "round_robin"
+	LoadBalancingLeastConnections = "least_connections"
+)
+
 type ProxyConfig struct {
-	Pattern   string
-	TargetURL *url.URL
+	Pattern        string
+	TargetURLs     []*url.URL
+	LoadBalancing  string
+	HealthCheck    *HealthCheckConfig    // nil disables health probes
+	CircuitBreaker *CircuitBreakerConfig // nil disables circuit breaking
+	Scopes         []string              // scopes an access token needs to use this proxy
+	Resource       string                // RFC 8707 resource indicator, BaseURL + Pattern
+	// Users must be in one of AllowedGroups, if set, and in none of DeniedGroups
+	AllowedGroups []string
+	DeniedGroups  []string
+	Policy        *PolicyConfig // nil when the proxy has no policy
+	MCP           *MCPConfig    // nil when MCP messages are not inspected
+	// nil forwards the caller's Authorization header unchanged
+	IdentityAssertion *IdentityAssertionConfig
+	TokenExchange     *TokenExchangeConfig // nil when backends cannot exchange tokens
+	UpstreamToken     *UpstreamTokenConfig // nil when the backend does not act as the user
+	Connections       []*ProxyConnectionConfig
+	UpstreamAuth      *UpstreamAuthConfig // nil when the backend does not require its own authorization
+	// Streaming, zero disables
+	HeartbeatInterval     time.Duration // SSE comment sent when the stream is quiet
+	IdleTimeout           time.Duration // SSE streams without events are closed
+	ResponseHeaderTimeout time.Duration
+	// RFC 9728 protected resource metadata
+	ResourceMetadataURL   string
+	ResourceName          string
+	ResourceDocumentation string
 }
 
-func NewConfig() (*Config, []*ProxyConfig, error) {
+type Proxies []*ProxyConfig
+
+func (proxies Proxies) Resources() []string {
+	resources := make([]string, 0, len(proxies))
+	for _, p := range proxies {
+		resources = append(resources, p.Resource)
+	}
+	return resources
+}
+
+// IdentityAssertions reports whether any proxy forwards signed identity assertions.
+func (proxies Proxies) IdentityAssertions() bool {
+	for _, p := range proxies {
+		if p.IdentityAssertion != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// ScopesSupported returns every scope required by at least one proxy.
+func (proxies Proxies) ScopesSupported() []string {
+	scopes := []string{}
+	for _, p := range proxies {
+		for _, s := range p.Scopes {
+			if !slices.Contains(scopes, s) {
+				scopes = append(scopes, s)
+			}
+		}
+	}
+	return scopes
+}
+
+func NewConfig() (*Config, Proxies, error) {
 	_ = godotenv.Load()
 
 	var cfg Config
@@ -47,18 +156,82 @@ func NewConfig() (*Config, []*ProxyConfig, error) {
 		return nil, nil, fmt.Errorf("base url must not end with a slash: %s", cfg.BaseURL)
 	}
 
-	if strings.HasSuffix(cfg.OAuthOktaConfig.OktaURL, "/") {
-		return nil, nil, fmt.Errorf("okta url must not end with a slash: %s", cfg.OAuthOktaConfig.OktaURL)
+	if cfg.TokenConfig.AccessTokenFormat != "opaque" && cfg.TokenConfig.AccessTokenFormat != "jwt" {
+		return nil, nil, fmt.Errorf("access token format must be opaque or jwt: %s", cfg.TokenConfig.AccessTokenFormat)
+	}
+
+	if cfg.TokenConfig.SigningAlg != "RS256" && cfg.TokenConfig.SigningAlg != "ES256" {
+		return nil, nil, fmt.Errorf("signing alg must be RS256 or ES256: %s", cfg.TokenConfig.SigningAlg)
 	}
 
-	if strings.HasSuffix(cfg.OAuthOktaConfig.OktaRedirectURI, "/") {
-		return nil, nil, fmt.Errorf("okta redirect uri must not end with a slash: %s", cfg.OAuthOktaConfig.OktaRedirectURI)
+	var primary *IdentityProviderConfig
+	switch cfg.OAuthProvider {
+	case "okta":
+		primary = &IdentityProviderConfig{
+			Name:         "okta",
+			DisplayName:  "Okta",
+			Type:         "okta",
+			OktaURL:      cfg.OAuthOktaConfig.OktaURL,
+			ClientID:     cfg.OAuthOktaConfig.OktaClientID,
+			ClientSecret: cfg.OAuthOktaConfig.OktaClientSecret,
+			RedirectURI:  cfg.OAuthOktaConfig.OktaRedirectURI,
+			Scopes:       cfg.OAuthOktaConfig.OktaScopes,
+			Domains:      cfg.OAuthProviderDomains,
+
+			AuthorizationServerID: cfg.OAuthOktaConfig.OktaAuthorizationServerID,
+			OktaAPIToken:          cfg.OAuthOktaConfig.OktaAPIToken,
+		}
+	case "oidc":
+		primary = &IdentityProviderConfig{
+			Name:         "oidc",
+			DisplayName:  "Single Sign-On",
+			Type:         "oidc",
+			IssuerURL:    cfg.OAuthOIDCConfig.OIDCIssuerURL,
+			ClientID:     cfg.OAuthOIDCConfig.OIDCClientID,
+			ClientSecret: cfg.OAuthOIDCConfig.OIDCClientSecret,
+			RedirectURI:  cfg.OAuthOIDCConfig.OIDCRedirectURI,
+			Scopes:       cfg.OAuthOIDCConfig.OIDCScopes,
+			Domains:      cfg.OAuthProviderDomains,
+		}
+	default:
+		return nil, nil, fmt.Errorf("oauth provider must be okta or oidc: %s", cfg.OAuthProvider)
+	}
+	if err := primary.validate(); err != nil {
+		return nil, nil, err
+	}
+	if cfg.TrustedProxyHops < 1 {
+		return nil, nil, fmt.Errorf("trusted proxy hops must be at least 1: %d", cfg.TrustedProxyHops)
 	}
+	cfg.IdentityProviders = []*IdentityProviderConfig{primary}
 
-	// Load proxy settings from config.yaml if exists
+	// Load proxy and identity provider settings from config.yaml if exists
 	type proxyConfig struct {
-		Pattern   string `yaml:"pattern"`
-		TargetURL string `yaml:"target_url"`
+		Pattern    string   `yaml:"pattern"`
+		TargetURL  string   `yaml:"target_url"`
+		TargetURLs []string `yaml:"target_urls"` // replicas of the backend
+		// round_robin (default) or least_connections
+		LoadBalancing  string                `yaml:"load_balancing"`
+		HealthCheck    *HealthCheckConfig    `yaml:"health_check"`
+		CircuitBreaker *CircuitBreakerConfig `yaml:"circuit_breaker"`
+		Scopes         []string              `yaml:"scopes"`
+		// Upstream identity provider groups
+		AllowedGroups []string      `yaml:"allowed_groups"`
+		DeniedGroups  []string      `yaml:"denied_groups"`
+		Policy        *PolicyConfig `yaml:"policy"`
+		MCP           *MCPConfig    `yaml:"mcp"`
+		// Identity forwarded to the backend
+		IdentityAssertion *IdentityAssertionConfig `yaml:"identity_assertion"`
+		TokenExchange     *TokenExchangeConfig     `yaml:"token_exchange"`
+		UpstreamToken     *UpstreamTokenConfig     `yaml:"upstream_token"`
+		Connections       []*ProxyConnectionConfig `yaml:"connections"`
+		UpstreamAuth      *UpstreamAuthConfig      `yaml:"upstream_auth"`
+		// Streaming
+		HeartbeatInterval     *time.Duration `yaml:"heartbeat_interval"`
+		IdleTimeout           time.Duration  `yaml:"idle_timeout"`
+		ResponseHeaderTimeout time.Duration  `yaml:"response_header_timeout"`
+		// Protected resource metadata
+		ResourceName          string `yaml:"resource_name"`
+		ResourceDocumentation string `yaml:"resource_documentation"`
 	}
 	f, err := os.Open("config.yaml")
 	if err != nil {
@@ -67,21 +240,65 @@ func NewConfig() (*Config, []*ProxyConfig, error) {
 	defer f.Close()
 	d := yaml.NewDecoder(f)
 	proxies := struct {
-		Proxies []*proxyConfig `yaml:"proxies"`
+		Proxies           []*proxyConfig            `yaml:"proxies"`
+		IdentityProviders []*identityProviderConfig `yaml:"identity_providers"`
+		Connections       []*connectionConfig       `yaml:"connections"`
 	}{}
 	if err := d.Decode(&proxies); err != nil {
 		return &cfg, nil, fmt.Errorf("failed to decode config.yaml: %w", err)
 	}
-	proxyConfigs := []*ProxyConfig{}
+	for _, p := range proxies.IdentityProviders {
+		idp := p.resolve()
+		if err := idp.validate(); err != nil {
+			return nil, nil, err
+		}
+		cfg.IdentityProviders = append(cfg.IdentityProviders, idp)
+	}
+	for _, c := range proxies.Connections {
+		conn := c.resolve()
+		if err := conn.validate(); err != nil {
+			return nil, nil, err
+		}
+		if slices.ContainsFunc(cfg.Connections, func(other *ConnectionConfig) bool { return other.Name == conn.Name }) {
+			return nil, nil, fmt.Errorf("duplicate connection: %s", conn.Name)
+		}
+		cfg.Connections = append(cfg.Connections, conn)
+	}
+	if len(cfg.Connections) > 0 && cfg.UpstreamTokenKey == "" {
+		return nil, nil, fmt.Errorf("UPSTREAM_TOKEN_KEY is required to store the tokens of connections")
+	}
+	proxyResources := make([]string, 0, len(proxies.Proxies))
+	for _, p := range proxies.Proxies {
+		proxyResources = append(proxyResources, cfg.BaseURL+p.Pattern)
+	}
+	proxyConfigs := Proxies{}
 	for _, p := range proxies.Proxies {
-		if p.TargetURL == "" || p.Pattern == "" {
+		targetURLs := p.TargetURLs
+		if p.TargetURL != "" {
+			targetURLs = append([]string{p.TargetURL}, targetURLs...)
+		}
+		if len(targetURLs) == 0 || p.Pattern == "" {
 			return nil, nil, fmt.Errorf("target url and pattern are required for proxy: %v", p)
 		}
-		if !strings.HasPrefix(p.TargetURL, "http") {
-			return nil, nil, fmt.Errorf("target url must start with http(s): %v", p)
+		targets := make([]*url.URL, 0, len(targetURLs))
+		for _, targetURL := range targetURLs {
+			if !strings.HasPrefix(targetURL, "http") {
+				return nil, nil, fmt.Errorf("target url must start with http(s): %v", p)
+			}
+			if strings.HasSuffix(targetURL, "/") {
+				return nil, nil, fmt.Errorf("target url must not end with a slash: %v", p)
+			}
+			target, err := url.Parse(targetURL)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to parse target url: %w", err)
+			}
+			targets = append(targets, target)
 		}
-		if strings.HasSuffix(p.TargetURL, "/") {
-			return nil, nil, fmt.Errorf("target url must not end with a slash: %v", p)
+		if p.LoadBalancing == "" {
+			p.LoadBalancing = LoadBalancingRoundRobin
+		}
+		if p.LoadBalancing != LoadBalancingRoundRobin && p.LoadBalancing != LoadBalancingLeastConnections {
+			return nil, nil, fmt.Errorf("load balancing must be round_robin or least_connections: %v", p)
 		}
 		if !strings.HasPrefix(p.Pattern, "/") {
 			return nil, nil, fmt.Errorf("pattern must start with a slash: %v", p)
@@ -89,14 +306,124 @@ func NewConfig() (*Config, []*ProxyConfig, error) {
 		if !strings.HasSuffix(p.Pattern, "/") {
 			return nil, nil, fmt.Errorf("pattern must end with a slash: %v", p)
 		}
-		url, err := url.Parse(p.TargetURL)
-		if err != nil {
-			return nil, nil, fmt.Errorf("failed to parse target url: %w", err)
+		if p.ResourceDocumentation != "" && !strings.HasPrefix(p.ResourceDocumentation, "http") {
+			return nil, nil, fmt.Errorf("resource documentation must start with http(s): %v", p)
+		}
+		for _, scope := range p.Scopes {
+			if scope == "" || strings.ContainsAny(scope, " \"\\") {
+				return nil, nil, fmt.Errorf("invalid scope %q for proxy: %v", scope, p)
+			}
+		}
+		if p.HealthCheck != nil {
+			if err := p.HealthCheck.validate(); err != nil {
+				return nil, nil, fmt.Errorf("invalid health check for proxy %s: %w", p.Pattern, err)
+			}
+		}
+		if p.CircuitBreaker != nil {
+			if err := p.CircuitBreaker.validate(); err != nil {
+				return nil, nil, fmt.Errorf("invalid circuit breaker for proxy %s: %w", p.Pattern, err)
+			}
+		}
+		if p.Policy != nil {
+			if err := p.Policy.validate(); err != nil {
+				return nil, nil, fmt.Errorf("invalid policy for proxy %s: %w", p.Pattern, err)
+			}
+		}
+		if p.IdentityAssertion != nil {
+			if err := p.IdentityAssertion.validate(); err != nil {
+				return nil, nil, fmt.Errorf("invalid identity assertion for proxy %s: %w", p.Pattern, err)
+			}
+			if p.IdentityAssertion.Audience == "" {
+				p.IdentityAssertion.Audience = cfg.BaseURL + p.Pattern
+			}
+		}
+		if p.TokenExchange != nil {
+			if err := p.TokenExchange.validate(cfg.BaseURL, proxyResources, cfg.ResourceServers); err != nil {
+				return nil, nil, fmt.Errorf("invalid token exchange for proxy %s: %w", p.Pattern, err)
+			}
+		}
+		if p.UpstreamToken != nil {
+			if err := p.UpstreamToken.validate(); err != nil {
+				return nil, nil, fmt.Errorf("invalid upstream token for proxy %s: %w", p.Pattern, err)
+			}
+			if cfg.UpstreamTokenKey == "" {
+				return nil, nil, fmt.Errorf("UPSTREAM_TOKEN_KEY is required for the upstream token of proxy %s", p.Pattern)
+			}
+		}
+		// Headers sent to the backend on behalf of the user
+		headers := []string{}
+		if p.IdentityAssertion != nil {
+			headers = append(headers, p.IdentityAssertion.Header)
+		}
+		if p.UpstreamToken != nil {
+			headers = append(headers, p.UpstreamToken.Header)
+		}
+		for _, c := range p.Connections {
+			if err := c.validate(cfg.Connections); err != nil {
+				return nil, nil, fmt.Errorf("invalid connection for proxy %s: %w", p.Pattern, err)
+			}
+			headers = append(headers, c.Header)
+		}
+		if p.UpstreamAuth != nil {
+			if err := p.UpstreamAuth.validate(p.Pattern); err != nil {
+				return nil, nil, fmt.Errorf("invalid upstream auth for proxy %s: %w", p.Pattern, err)
+			}
+			if cfg.UpstreamTokenKey == "" {
+				return nil, nil, fmt.Errorf("UPSTREAM_TOKEN_KEY is required for the upstream auth of proxy %s", p.Pattern)
+			}
+			// The token of the backend replaces the token of the gateway
+			p.Connections = append(p.Connections, &ProxyConnectionConfig{
+				Name:   p.UpstreamAuth.Connection,
+				Header: "Authorization",
+			})
+			headers = append(headers, "Authorization")
+		}
+		slices.Sort(headers)
+		if len(slices.Compact(headers)) != len(headers) {
+			return nil, nil, fmt.Errorf("identity assertion, upstream token and connections of proxy %s must use different headers", p.Pattern)
+		}
+		if p.MCP != nil {
+			if err := p.MCP.validate(); err != nil {
+				return nil, nil, fmt.Errorf("invalid mcp rules for proxy %s: %w", p.Pattern, err)
+			}
+		}
+		heartbeatInterval := defaultHeartbeatInterval
+		if p.HeartbeatInterval != nil {
+			heartbeatInterval = *p.HeartbeatInterval
+		}
+		if heartbeatInterval < 0 || p.IdleTimeout < 0 || p.ResponseHeaderTimeout < 0 {
+			return nil, nil, fmt.Errorf("heartbeat interval and timeouts must not be negative: %v", p)
 		}
 		proxyConfigs = append(proxyConfigs, &ProxyConfig{
-			Pattern:   p.Pattern,
-			TargetURL: url,
+			Pattern:               p.Pattern,
+			TargetURLs:            targets,
+			LoadBalancing:         p.LoadBalancing,
+			HealthCheck:           p.HealthCheck,
+			CircuitBreaker:        p.CircuitBreaker,
+			Scopes:                p.Scopes,
+			Resource:              cfg.BaseURL + p.Pattern,
+			AllowedGroups:         p.AllowedGroups,
+			DeniedGroups:          p.DeniedGroups,
+			Policy:                p.Policy,
+			MCP:                   p.MCP,
+			IdentityAssertion:     p.IdentityAssertion,
+			TokenExchange:         p.TokenExchange,
+			UpstreamToken:         p.UpstreamToken,
+			Connections:           p.Connections,
+			UpstreamAuth:          p.UpstreamAuth,
+			HeartbeatInterval:     heartbeatInterval,
+			IdleTimeout:           p.IdleTimeout,
+			ResponseHeaderTimeout: p.ResponseHeaderTimeout,
+			ResourceMetadataURL:   cfg.BaseURL + "/.well-known/oauth-protected-resource" + strings.TrimSuffix(p.Pattern, "/"),
+			ResourceName:          p.ResourceName,
+			ResourceDocumentation: p.ResourceDocumentation,
 		})
 	}
+	supported := proxyConfigs.ScopesSupported()
+	for _, s := range cfg.DefaultScopes {
+		if !slices.Contains(supported, s) {
+			return nil, nil, fmt.Errorf("default scope %q is not a scope of any proxy", s)
+		}
+	}
 	return &cfg, proxyConfigs, nil
 }
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ConnectionConfig is a third-party OAuth provider users connect their own
+// accounts of, such as GitHub, Jira or Google.
+type ConnectionConfig struct {
+	Name             string
+	DisplayName      string
+	AuthorizationURL string
+	TokenURL         string
+	ClientID         string
+	ClientSecret     string
+	Scopes           []string
+	// Extra parameters of the authorization request, e.g. access_type=offline for Google
+	AuthParams map[string]string
+}
+
+type connectionConfig struct {
+	Name             string            `yaml:"name"`
+	DisplayName      string            `yaml:"display_name"`
+	AuthorizationURL string            `yaml:"authorization_url"`
+	TokenURL         string            `yaml:"token_url"`
+	ClientID         string            `yaml:"client_id"`
+	ClientSecretEnv  string            `yaml:"client_secret_env"`
+	Scopes           []string          `yaml:"scopes"`
+	AuthParams       map[string]string `yaml:"auth_params"`
+}
+
+// resolve reads the client secret from the environment so that config.yaml
+// does not have to contain secrets.
+func (c *connectionConfig) resolve() *ConnectionConfig {
+	displayName := c.DisplayName
+	if displayName == "" {
+		displayName = c.Name
+	}
+	return &ConnectionConfig{
+		Name:             c.Name,
+		DisplayName:      displayName,
+		AuthorizationURL: c.AuthorizationURL,
+		TokenURL:         c.TokenURL,
+		ClientID:         c.ClientID,
+		ClientSecret:     os.Getenv(c.ClientSecretEnv),
+		Scopes:           c.Scopes,
+		AuthParams:       c.AuthParams,
+	}
+}
+
+func (c *ConnectionConfig) validate() error {
+	if c.Name == "" || strings.ContainsAny(c.Name, ":/ ") {
+		return fmt.Errorf("connection name is required and must not contain ':', '/' or spaces: %q", c.Name)
+	}
+	if c.ClientID == "" || c.ClientSecret == "" {
+		return fmt.Errorf("client id and client secret are required for connection: %s", c.Name)
+	}
+	if !strings.HasPrefix(c.AuthorizationURL, "https://") || !strings.HasPrefix(c.TokenURL, "https://") {
+		return fmt.Errorf("authorization url and token url must start with https for connection: %s", c.Name)
+	}
+	return nil
+}
+
+// ProxyConnectionConfig is a connection the users of a proxy need. The
+// access token of their account is sent to the backend in Header.
+type ProxyConnectionConfig struct {
+	Name   string `yaml:"name"`
+	Header string `yaml:"header"` // default X-Connection-Token, Authorization sends a Bearer token
+}
+
+func (c *ProxyConnectionConfig) validate(connections []*ConnectionConfig) error {
+	found := false
+	for _, conn := range connections {
+		if conn.Name == c.Name {
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("unknown connection: %s", c.Name)
+	}
+	if c.Header == "" {
+		c.Header = "X-Connection-Token"
+	}
+	c.Header = http.CanonicalHeaderKey(c.Header)
+	return nil
+}
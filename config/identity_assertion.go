@@ -0,0 +1,42 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// IdentityAssertionClaims are the optional claims an identity assertion can carry.
+var IdentityAssertionClaims = []string{"email", "groups", "amr", "client_id", "scope"}
+
+// IdentityAssertionConfig replaces the caller's access token with a
+// short-lived JWT signed by the gateway when a request is proxied.
+type IdentityAssertionConfig struct {
+	Header   string        `yaml:"header"`   // default X-MCP-Identity, Authorization sends a Bearer token
+	Claims   []string      `yaml:"claims"`   // default email, groups and client_id
+	Audience string        `yaml:"audience"` // default the resource of the proxy
+	TTL      time.Duration `yaml:"ttl"`      // default 60s
+}
+
+func (c *IdentityAssertionConfig) validate() error {
+	if c.Header == "" {
+		c.Header = "X-MCP-Identity"
+	}
+	c.Header = http.CanonicalHeaderKey(c.Header)
+	if c.Claims == nil {
+		c.Claims = []string{"email", "groups", "client_id"}
+	}
+	for _, claim := range c.Claims {
+		if !slices.Contains(IdentityAssertionClaims, claim) {
+			return fmt.Errorf("identity assertion claim must be one of %v: %s", IdentityAssertionClaims, claim)
+		}
+	}
+	if c.TTL == 0 {
+		c.TTL = 60 * time.Second
+	}
+	if c.TTL < 0 || c.TTL > 10*time.Minute {
+		return fmt.Errorf("identity assertion ttl must be at most 10m: %s", c.TTL)
+	}
+	return nil
+}
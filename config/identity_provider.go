@@ -0,0 +1,104 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// IdentityProviderConfig is an upstream identity provider users can log in with.
+type IdentityProviderConfig struct {
+	Name         string
+	DisplayName  string
+	Type         string // okta or oidc
+	OktaURL      string // okta only
+	IssuerURL    string // oidc only
+	ClientID     string
+	ClientSecret string
+	RedirectURI  string
+	Scopes       []string
+	Domains      []string // email domains routed to this provider
+	// Okta authorization server ID, "default" or "org"; okta only
+	AuthorizationServerID string
+	OktaAPIToken          string // okta only
+}
+
+type identityProviderConfig struct {
+	Name            string   `yaml:"name"`
+	DisplayName     string   `yaml:"display_name"`
+	Type            string   `yaml:"type"`
+	OktaURL         string   `yaml:"okta_url"`
+	IssuerURL       string   `yaml:"issuer_url"`
+	ClientID        string   `yaml:"client_id"`
+	ClientSecretEnv string   `yaml:"client_secret_env"`
+	RedirectURI     string   `yaml:"redirect_uri"`
+	Scopes          []string `yaml:"scopes"`
+	Domains         []string `yaml:"domains"`
+
+	AuthorizationServerID string `yaml:"authorization_server_id"`
+	APITokenEnv           string `yaml:"api_token_env"`
+}
+
+// resolve reads the client secret and API token from the environment so that config.yaml
+// does not have to contain secrets.
+func (p *identityProviderConfig) resolve() *IdentityProviderConfig {
+	scopes := p.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile", "email"}
+	}
+	authorizationServerID := p.AuthorizationServerID
+	if authorizationServerID == "" {
+		authorizationServerID = "default"
+	}
+	return &IdentityProviderConfig{
+		Name:         p.Name,
+		DisplayName:  p.DisplayName,
+		Type:         p.Type,
+		OktaURL:      p.OktaURL,
+		IssuerURL:    p.IssuerURL,
+		ClientID:     p.ClientID,
+		ClientSecret: os.Getenv(p.ClientSecretEnv),
+		RedirectURI:  p.RedirectURI,
+		Scopes:       scopes,
+		Domains:      p.Domains,
+
+		AuthorizationServerID: authorizationServerID,
+		OktaAPIToken:          os.Getenv(p.APITokenEnv),
+	}
+}
+
+func (p *IdentityProviderConfig) validate() error {
+	if p.Name == "" || strings.ContainsAny(p.Name, ":/ ") {
+		return fmt.Errorf("identity provider name is required and must not contain ':', '/' or spaces: %q", p.Name)
+	}
+	switch p.Type {
+	case "okta":
+		if p.OktaURL == "" || p.ClientID == "" || p.ClientSecret == "" || p.RedirectURI == "" {
+			return fmt.Errorf("okta url, client id, client secret and redirect uri are required for the okta provider: %s", p.Name)
+		}
+
+		if strings.HasSuffix(p.OktaURL, "/") {
+			return fmt.Errorf("okta url must not end with a slash: %s", p.OktaURL)
+		}
+
+		if p.AuthorizationServerID == "" || strings.ContainsAny(p.AuthorizationServerID, "/?# ") {
+			return fmt.Errorf("invalid okta authorization server id: %q", p.AuthorizationServerID)
+		}
+	case "oidc":
+		if p.IssuerURL == "" || p.ClientID == "" || p.RedirectURI == "" {
+			return fmt.Errorf("oidc issuer url, client id and redirect uri are required for the oidc provider: %s", p.Name)
+		}
+	default:
+		return fmt.Errorf("identity provider type must be okta or oidc: %s", p.Type)
+	}
+
+	if !slices.Contains(p.Scopes, "openid") {
+		return fmt.Errorf("scopes must include openid: %s %v", p.Name, p.Scopes)
+	}
+
+	if strings.HasSuffix(p.RedirectURI, "/") {
+		return fmt.Errorf("redirect uri must not end with a slash: %s", p.RedirectURI)
+	}
+	return nil
+}
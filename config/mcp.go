@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MCPConfig restricts the MCP tools, resources and prompts of a proxy. Names
+// (resource URIs for resources) without a matching rule are allowed, or
+// denied with Default "deny".
+type MCPConfig struct {
+	Default   string     `yaml:"default"` // allow (default) or deny
+	Tools     []*MCPRule `yaml:"tools"`
+	Resources []*MCPRule `yaml:"resources"`
+	Prompts   []*MCPRule `yaml:"prompts"`
+}
+
+// MCPRule applies to the names matching the Name glob. '*' matches any
+// characters including '/', so "file:///secrets/*" covers nested resources;
+// '?' matches one character and [...] a character class. The first matching
+// rule decides. A user in a denied group or with a denied scope is rejected;
+// if allowed groups or scopes are set, the user needs one of them.
+type MCPRule struct {
+	Name          string   `yaml:"name"`
+	AllowedGroups []string `yaml:"allowed_groups"`
+	DeniedGroups  []string `yaml:"denied_groups"`
+	AllowedScopes []string `yaml:"allowed_scopes"`
+	DeniedScopes  []string `yaml:"denied_scopes"`
+	pattern       *regexp.Regexp
+}
+
+func (c *MCPConfig) validate() error {
+	switch c.Default {
+	case "":
+		c.Default = "allow"
+	case "allow", "deny":
+	default:
+		return fmt.Errorf("mcp default must be allow or deny: %s", c.Default)
+	}
+	for _, rules := range [][]*MCPRule{c.Tools, c.Resources, c.Prompts} {
+		for _, r := range rules {
+			if r.Name == "" {
+				return fmt.Errorf("name is required for mcp rule: %v", r)
+			}
+			pattern, err := compileGlob(r.Name)
+			if err != nil {
+				return fmt.Errorf("invalid mcp rule name %q: %w", r.Name, err)
+			}
+			r.pattern = pattern
+		}
+	}
+	return nil
+}
+
+// Match returns the first rule whose name pattern matches name.
+func Match(rules []*MCPRule, name string) *MCPRule {
+	for _, r := range rules {
+		if r.pattern.MatchString(name) {
+			return r
+		}
+	}
+	return nil
+}
+
+func compileGlob(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	// (?s) lets '*' and '?' match newlines, names are sent by the client
+	b.WriteString("(?s)^")
+	for i := 0; i < len(glob); i++ {
+		switch glob[i] {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '\\':
+			if i++; i == len(glob) {
+				return nil, fmt.Errorf("trailing backslash")
+			}
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated character class")
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
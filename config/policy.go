@@ -0,0 +1,47 @@
+package config
+
+import "fmt"
+
+// PolicyConfig holds the access rules of a proxy. Rules are evaluated in
+// order and the first rule whose expression is true decides; if none
+// matches, Default applies.
+type PolicyConfig struct {
+	Engine  string        `yaml:"engine"`  // expression language, cel (default)
+	Default string        `yaml:"default"` // allow or deny (default)
+	Rules   []*PolicyRule `yaml:"rules"`
+}
+
+type PolicyRule struct {
+	Name       string `yaml:"name"`
+	Effect     string `yaml:"effect"` // allow or deny
+	Expression string `yaml:"expression"`
+}
+
+func (p *PolicyConfig) validate() error {
+	if p.Engine == "" {
+		p.Engine = "cel"
+	}
+	if p.Engine != "cel" {
+		return fmt.Errorf("policy engine must be cel: %s", p.Engine)
+	}
+	if p.Default == "" {
+		p.Default = "deny"
+	}
+	if p.Default != "allow" && p.Default != "deny" {
+		return fmt.Errorf("policy default must be allow or deny: %s", p.Default)
+	}
+	names := map[string]bool{}
+	for _, r := range p.Rules {
+		if r.Name == "" || r.Expression == "" {
+			return fmt.Errorf("name and expression are required for policy rule: %v", r)
+		}
+		if names[r.Name] {
+			return fmt.Errorf("duplicate policy rule: %s", r.Name)
+		}
+		names[r.Name] = true
+		if r.Effect != "allow" && r.Effect != "deny" {
+			return fmt.Errorf("policy rule effect must be allow or deny: %s", r.Name)
+		}
+	}
+	return nil
+}
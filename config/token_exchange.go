@@ -0,0 +1,61 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// TokenExchangeConfig lets the backends of a proxy exchange the access token
+// of the caller for a token for another audience (RFC 8693).
+type TokenExchangeConfig struct {
+	// RESOURCE_SERVERS ids the backends authenticate with
+	ResourceServers []string                 `yaml:"resource_servers"`
+	Audiences       []*TokenExchangeAudience `yaml:"audiences"`
+}
+
+// TokenExchangeAudience is an audience exchanged tokens may be issued for.
+type TokenExchangeAudience struct {
+	Audience string   `yaml:"audience"`
+	Scopes   []string `yaml:"scopes"` // scopes the exchanged token may carry
+}
+
+func (c *TokenExchangeConfig) validate(baseURL string, proxyResources []string, resourceServers map[string]string) error {
+	if len(c.ResourceServers) == 0 {
+		return fmt.Errorf("resource servers are required")
+	}
+	for _, id := range c.ResourceServers {
+		if _, ok := resourceServers[id]; !ok {
+			return fmt.Errorf("resource server is not in RESOURCE_SERVERS: %s", id)
+		}
+	}
+	if len(c.Audiences) == 0 {
+		return fmt.Errorf("audiences are required")
+	}
+	for _, a := range c.Audiences {
+		u, err := url.Parse(a.Audience)
+		if err != nil || !u.IsAbs() || u.Fragment != "" {
+			return fmt.Errorf("audience must be an absolute URL: %q", a.Audience)
+		}
+		// Tokens for the base URL are valid for every proxy
+		if a.Audience == baseURL {
+			return fmt.Errorf("audience must not be the base url: %s", a.Audience)
+		}
+		// The proxy would accept the exchanged token like one issued to the client
+		if slices.Contains(proxyResources, a.Audience) {
+			return fmt.Errorf("audience must not be the resource of a proxy: %s", a.Audience)
+		}
+		for _, scope := range a.Scopes {
+			if scope == "" || strings.ContainsAny(scope, " \"\\") {
+				return fmt.Errorf("invalid scope %q for audience %s", scope, a.Audience)
+			}
+		}
+		if slices.ContainsFunc(c.Audiences, func(other *TokenExchangeAudience) bool {
+			return other != a && other.Audience == a.Audience
+		}) {
+			return fmt.Errorf("duplicate audience: %s", a.Audience)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// HealthCheckConfig probes every target of a proxy. A target is unhealthy
+// after UnhealthyThreshold failed probes in a row and healthy again after
+// HealthyThreshold successful ones.
+type HealthCheckConfig struct {
+	Path               string        `yaml:"path"`
+	Interval           time.Duration `yaml:"interval"`
+	Timeout            time.Duration `yaml:"timeout"`
+	UnhealthyThreshold int           `yaml:"unhealthy_threshold"`
+	HealthyThreshold   int           `yaml:"healthy_threshold"`
+}
+
+// CircuitBreakerConfig stops sending requests to a target after Failures
+// consecutive connection errors or 5xx responses. After Cooldown one trial
+// request is let through; it closes the circuit when it succeeds.
+type CircuitBreakerConfig struct {
+	Failures int           `yaml:"failures"`
+	Cooldown time.Duration `yaml:"cooldown"`
+}
+
+func (c *HealthCheckConfig) validate() error {
+	if c.Path == "" {
+		c.Path = "/"
+	}
+	if !strings.HasPrefix(c.Path, "/") {
+		return fmt.Errorf("health check path must start with a slash: %s", c.Path)
+	}
+	if c.Interval == 0 {
+		c.Interval = 10 * time.Second
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 2 * time.Second
+	}
+	if c.UnhealthyThreshold == 0 {
+		c.UnhealthyThreshold = 3
+	}
+	if c.HealthyThreshold == 0 {
+		c.HealthyThreshold = 1
+	}
+	if c.Interval < 0 || c.Timeout < 0 || c.UnhealthyThreshold < 0 || c.HealthyThreshold < 0 {
+		return fmt.Errorf("health check interval, timeout and thresholds must be positive")
+	}
+	return nil
+}
+
+func (c *CircuitBreakerConfig) validate() error {
+	if c.Failures == 0 {
+		c.Failures = 5
+	}
+	if c.Cooldown == 0 {
+		c.Cooldown = 30 * time.Second
+	}
+	if c.Failures < 0 || c.Cooldown < 0 {
+		return fmt.Errorf("circuit breaker failures and cooldown must be positive")
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UpstreamAuthConfig makes the gateway an OAuth client of a backend MCP
+// server that implements the MCP authorization spec. Users authorize the
+// gateway once through a connection, see ConnectionConfig.
+type UpstreamAuthConfig struct {
+	// Protected resource metadata, default discovered below the well-known path of the target url
+	ResourceMetadataURL string   `yaml:"resource_metadata_url"`
+	Scopes              []string `yaml:"scopes"`       // default scopes_supported of the metadata
+	DisplayName         string   `yaml:"display_name"` // default resource_name of the metadata
+	ClientName          string   `yaml:"client_name"`  // sent on dynamic client registration
+	// Name of the connection holding the tokens of the users
+	Connection string `yaml:"-"`
+}
+
+func (c *UpstreamAuthConfig) validate(pattern string) error {
+	if c.ResourceMetadataURL != "" && !strings.HasPrefix(c.ResourceMetadataURL, "http") {
+		return fmt.Errorf("resource metadata url must start with http(s): %s", c.ResourceMetadataURL)
+	}
+	for _, scope := range c.Scopes {
+		if scope == "" || strings.ContainsAny(scope, " \"\\") {
+			return fmt.Errorf("invalid scope %q", scope)
+		}
+	}
+	if c.ClientName == "" {
+		c.ClientName = "Secure MCP Okta Gateway"
+	}
+	// ':' keeps it apart from the names of configured connections
+	c.Connection = "mcp:" + pattern
+	return nil
+}
@@ -0,0 +1,17 @@
+package config
+
+import "net/http"
+
+// UpstreamTokenConfig injects the access token the identity provider issued
+// to the user at login, so that the backend can call APIs as the user.
+type UpstreamTokenConfig struct {
+	Header string `yaml:"header"` // default X-Upstream-Token, Authorization sends a Bearer token
+}
+
+func (c *UpstreamTokenConfig) validate() error {
+	if c.Header == "" {
+		c.Header = "X-Upstream-Token"
+	}
+	c.Header = http.CanonicalHeaderKey(c.Header)
+	return nil
+}
@@ -0,0 +1,293 @@
+package connections
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/securemcp/securemcp-okta-gateway/config"
+	"github.com/securemcp/securemcp-okta-gateway/kvs"
+	"github.com/securemcp/securemcp-okta-gateway/tokenstore"
+	"github.com/securemcp/securemcp-okta-gateway/util"
+	"golang.org/x/oauth2"
+)
+
+// Manager runs the consent flow users connect their third-party accounts
+// with and hands out the tokens of those accounts.
+type Manager struct {
+	baseURL     string
+	connections map[string]*connection
+	tokens      *tokenstore.Store
+	ticketKVS   *kvs.KVS // key: ticket, value: connect ticket
+	loginKVS    *kvs.KVS // key: sid, value: connect ticket of the login in progress
+	stateKVS    *kvs.KVS // key: sid, value: consent state
+}
+
+// ErrWrongUser is returned when the user who signed in is not the one the
+// connect link was issued to, e.g. because someone else's link was opened.
+var ErrWrongUser = errors.New("signed in as another user than the one the link was issued to")
+
+type connection struct {
+	name string
+	// Backend MCP servers are discovered on first use, see discover
+	upstream  *config.UpstreamAuthConfig
+	targetURL *url.URL
+
+	mu          sync.Mutex
+	displayName string
+	client      *connectionClient // nil until discovered
+}
+
+// connectionClient is the OAuth client of a connection. It is never changed
+// once created, a rediscovery replaces it, so it can be used without the lock.
+type connectionClient struct {
+	oauth2     *oauth2.Config
+	authParams map[string]string
+	http       *http.Client // sends token requests, nil uses http.DefaultClient
+	endpoint   string       // registration endpoint of the dynamically registered client
+}
+
+// Ticket names the connection and the user an account is connected for. It
+// is handed out to the MCP client of the user, and the browser opening it has
+// to sign in as that user, so a link passed on to someone else is useless.
+type Ticket struct {
+	Connection string `json:"connection"`
+	UID        string `json:"uid"`
+	Email      string `json:"email,omitempty"`
+}
+
+type consentState struct {
+	Ticket
+	LoginUID     string `json:"login_uid"` // user who signed in to the gateway
+	State        string `json:"state"`
+	CodeVerifier string `json:"code_verifier"`
+}
+
+func NewManager(baseURL string, configs []*config.ConnectionConfig, proxies config.Proxies, tokens *tokenstore.Store, rdb *redis.Client) *Manager {
+	m := &Manager{
+		baseURL:     baseURL,
+		connections: map[string]*connection{},
+		tokens:      tokens,
+		ticketKVS:   kvs.NewKVS(rdb, "connect_ticket", kvs.ConnectTicketTTL),
+		loginKVS:    kvs.NewKVS(rdb, "connect_login", kvs.OAuthStateTTL),
+		stateKVS:    kvs.NewKVS(rdb, "connect_state", kvs.OAuthStateTTL),
+	}
+	for _, c := range configs {
+		client := &connectionClient{
+			oauth2: &oauth2.Config{
+				ClientID:     c.ClientID,
+				ClientSecret: c.ClientSecret,
+				Endpoint: oauth2.Endpoint{
+					AuthURL:  c.AuthorizationURL,
+					TokenURL: c.TokenURL,
+				},
+				Scopes:      c.Scopes,
+				RedirectURL: m.redirectURI(),
+			},
+			authParams: c.AuthParams,
+		}
+		m.connections[c.Name] = &connection{
+			name:        c.Name,
+			displayName: c.DisplayName,
+			client:      client,
+		}
+		tokens.Register(providerName(c.Name), client.oauth2, nil)
+	}
+	for _, p := range proxies {
+		if p.UpstreamAuth == nil {
+			continue
+		}
+		m.connections[p.UpstreamAuth.Connection] = &connection{
+			name:        p.UpstreamAuth.Connection,
+			displayName: p.UpstreamAuth.DisplayName,
+			upstream:    p.UpstreamAuth,
+			targetURL:   p.TargetURLs[0],
+		}
+	}
+	return m
+}
+
+func (m *Manager) redirectURI() string {
+	return m.baseURL + "/connections/callback"
+}
+
+// providerName keeps connections apart from identity providers of the same name.
+func providerName(connection string) string {
+	return "connection:" + connection
+}
+
+// client returns the OAuth client of the connection, discovering backend MCP
+// servers on first use. Failed discoveries are retried on the next call.
+func (m *Manager) client(ctx context.Context, name string) (*connectionClient, error) {
+	c, ok := m.connections[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown connection: %s", name)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client == nil {
+		if err := m.discover(ctx, c); err != nil {
+			return nil, err
+		}
+	}
+	return c.client, nil
+}
+
+// DisplayName returns the name of the connection shown to users.
+func (m *Manager) DisplayName(name string) string {
+	c, ok := m.connections[name]
+	if !ok {
+		return name
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.displayName == "" {
+		return name
+	}
+	return c.displayName
+}
+
+// Token returns the access token of the account the user connected, or
+// tokenstore.ErrNotFound when there is none.
+func (m *Manager) Token(ctx context.Context, name, uid string) (*oauth2.Token, error) {
+	// Refreshing needs the client of the connection
+	if _, err := m.client(ctx, name); err != nil {
+		return nil, err
+	}
+	return m.tokens.Token(ctx, tokenstore.ConnectionKey(name, uid))
+}
+
+// Disconnect forgets the account the user connected, e.g. after the backend
+// rejected its token.
+func (m *Manager) Disconnect(ctx context.Context, name, uid string) error {
+	return m.tokens.Delete(ctx, tokenstore.ConnectionKey(name, uid))
+}
+
+// ConnectURL returns the link the user opens to connect an account.
+func (m *Manager) ConnectURL(ctx context.Context, name, uid, email string) (string, error) {
+	if _, err := m.client(ctx, name); err != nil {
+		return "", err
+	}
+	ticketJSON, err := json.Marshal(&Ticket{Connection: name, UID: uid, Email: email})
+	if err != nil {
+		return "", err
+	}
+	ticket := util.RandString(32)
+	if err := m.ticketKVS.Set(ctx, ticket, ticketJSON); err != nil {
+		return "", err
+	}
+	return m.baseURL + "/connections/connect?ticket=" + url.QueryEscape(ticket), nil
+}
+
+// GetTicket returns the ticket without using it up, so that the user can be
+// asked to confirm the connection first.
+func (m *Manager) GetTicket(ctx context.Context, ticket string) (*Ticket, error) {
+	ticketJSON, err := m.ticketKVS.Get(ctx, ticket)
+	if err != nil {
+		return nil, err
+	}
+	var t Ticket
+	if err := json.Unmarshal([]byte(ticketJSON), &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// StartLogin uses up the ticket and keeps it in the browser session sid
+// while the user signs in to the gateway.
+func (m *Manager) StartLogin(ctx context.Context, sid, ticket string) (*Ticket, error) {
+	ticketJSON, err := m.ticketKVS.GetDel(ctx, ticket)
+	if err != nil {
+		return nil, err
+	}
+	var t Ticket
+	if err := json.Unmarshal([]byte(ticketJSON), &t); err != nil {
+		return nil, err
+	}
+	if err := m.loginKVS.Set(ctx, sid, ticketJSON); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// AuthCodeURL starts the authorization code flow of the connection in the
+// browser session sid once the user signed in as uid. It returns
+// ErrWrongUser unless uid is the user of the ticket.
+func (m *Manager) AuthCodeURL(ctx context.Context, sid, uid string) (string, *Ticket, error) {
+	ticketJSON, err := m.loginKVS.GetDel(ctx, sid)
+	if err != nil {
+		return "", nil, err
+	}
+	state := &consentState{
+		LoginUID:     uid,
+		State:        util.RandString(16),
+		CodeVerifier: util.RandString(96),
+	}
+	if err := json.Unmarshal([]byte(ticketJSON), &state.Ticket); err != nil {
+		return "", nil, err
+	}
+	if state.UID != uid {
+		return "", &state.Ticket, ErrWrongUser
+	}
+	client, err := m.client(ctx, state.Connection)
+	if err != nil {
+		return "", nil, err
+	}
+	stateJSON, err := json.Marshal(state)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := m.stateKVS.Set(ctx, sid, stateJSON); err != nil {
+		return "", nil, err
+	}
+	opts := []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("code_challenge", util.S256(state.CodeVerifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	}
+	for k, v := range client.authParams {
+		opts = append(opts, oauth2.SetAuthURLParam(k, v))
+	}
+	return client.oauth2.AuthCodeURL(state.State, opts...), &state.Ticket, nil
+}
+
+// Callback completes the flow started by AuthCodeURL in the same browser
+// session and stores the tokens of the connected account for the user who
+// signed in.
+func (m *Manager) Callback(ctx context.Context, sid, state, code string) (*Ticket, error) {
+	stateJSON, err := m.stateKVS.GetDel(ctx, sid)
+	if err != nil {
+		return nil, err
+	}
+	var saved consentState
+	if err := json.Unmarshal([]byte(stateJSON), &saved); err != nil {
+		return nil, err
+	}
+	if saved.State != state {
+		return nil, fmt.Errorf("invalid state: %s", state)
+	}
+	if saved.LoginUID != saved.UID {
+		return &saved.Ticket, ErrWrongUser
+	}
+	client, err := m.client(ctx, saved.Connection)
+	if err != nil {
+		return nil, err
+	}
+	exchangeCtx := ctx
+	if client.http != nil {
+		exchangeCtx = context.WithValue(ctx, oauth2.HTTPClient, client.http)
+	}
+	token, err := client.oauth2.Exchange(exchangeCtx, code, oauth2.SetAuthURLParam("code_verifier", saved.CodeVerifier))
+	if err != nil {
+		m.checkClient(ctx, saved.Connection, client, err)
+		return nil, fmt.Errorf("failed to exchange code: %w", err)
+	}
+	if err := m.tokens.Save(ctx, tokenstore.ConnectionKey(saved.Connection, saved.UID), providerName(saved.Connection), token); err != nil {
+		return nil, err
+	}
+	return &saved.Ticket, nil
+}
@@ -0,0 +1,302 @@
+package connections
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/securemcp/securemcp-okta-gateway/tokenstore"
+	"golang.org/x/oauth2"
+)
+
+// maxMetadataSize limits metadata and registration responses of backends.
+const maxMetadataSize = 1 << 20
+
+var discoveryClient = &http.Client{Timeout: 10 * time.Second}
+
+// tokenTimeout limits token requests to the authorization servers of backends.
+const tokenTimeout = 10 * time.Second
+
+// RFC 9728 protected resource metadata
+type resourceMetadata struct {
+	Resource             string   `json:"resource"`
+	AuthorizationServers []string `json:"authorization_servers"`
+	ScopesSupported      []string `json:"scopes_supported"`
+	ResourceName         string   `json:"resource_name"`
+}
+
+// RFC 8414 authorization server metadata
+type authorizationServerMetadata struct {
+	Issuer                        string   `json:"issuer"`
+	AuthorizationEndpoint         string   `json:"authorization_endpoint"`
+	TokenEndpoint                 string   `json:"token_endpoint"`
+	RegistrationEndpoint          string   `json:"registration_endpoint"`
+	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported"`
+}
+
+// RFC 7591 client information
+type registeredClient struct {
+	ClientID                string `json:"client_id"`
+	ClientSecret            string `json:"client_secret,omitempty"`
+	TokenEndpointAuthMethod string `json:"token_endpoint_auth_method,omitempty"`
+}
+
+// discover follows the MCP authorization spec: the protected resource
+// metadata of the backend names its authorization server, whose metadata
+// has the endpoints the gateway registers itself at and sends users to.
+func (m *Manager) discover(ctx context.Context, c *connection) error {
+	prm, err := fetchResourceMetadata(ctx, c.upstream.ResourceMetadataURL, c.targetURL)
+	if err != nil {
+		return err
+	}
+	// RFC 9728 section 3.3: metadata of another resource must not be used,
+	// it could send users and their tokens to an attacker's server
+	resource := c.targetURL.String()
+	if prm.Resource != resource {
+		return fmt.Errorf("protected resource metadata is for resource %q, expected %q", prm.Resource, resource)
+	}
+	asm, err := fetchAuthorizationServerMetadata(ctx, prm.AuthorizationServers[0])
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(asm.CodeChallengeMethodsSupported, "S256") {
+		return fmt.Errorf("authorization server %s does not support PKCE with S256", asm.Issuer)
+	}
+	if asm.RegistrationEndpoint == "" {
+		return fmt.Errorf("authorization server %s does not support dynamic client registration", asm.Issuer)
+	}
+	client, err := m.registerClient(ctx, asm.RegistrationEndpoint, c.upstream.ClientName)
+	if err != nil {
+		return err
+	}
+
+	scopes := c.upstream.Scopes
+	if len(scopes) == 0 {
+		scopes = prm.ScopesSupported
+	}
+	authStyle := oauth2.AuthStyleInHeader
+	if client.TokenEndpointAuthMethod == "none" || client.TokenEndpointAuthMethod == "client_secret_post" {
+		authStyle = oauth2.AuthStyleInParams
+	}
+	c.client = &connectionClient{
+		oauth2: &oauth2.Config{
+			ClientID:     client.ClientID,
+			ClientSecret: client.ClientSecret,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:   asm.AuthorizationEndpoint,
+				TokenURL:  asm.TokenEndpoint,
+				AuthStyle: authStyle,
+			},
+			Scopes:      scopes,
+			RedirectURL: m.redirectURI(),
+		},
+		// Tokens are bound to the backend with RFC 8707 resource indicators
+		authParams: map[string]string{"resource": resource},
+		http: &http.Client{
+			Timeout:   tokenTimeout,
+			Transport: &resourceTransport{resource: resource, base: http.DefaultTransport},
+		},
+		endpoint: asm.RegistrationEndpoint,
+	}
+	if c.displayName == "" {
+		c.displayName = prm.ResourceName
+	}
+	m.tokens.Register(providerName(c.name), c.client.oauth2, c.client.http)
+	return nil
+}
+
+// checkClient forgets the dynamically registered client when the
+// authorization server no longer knows it, so that the next use registers
+// again. A client that was already replaced is left alone.
+func (m *Manager) checkClient(ctx context.Context, name string, client *connectionClient, err error) {
+	var retrieveErr *oauth2.RetrieveError
+	if !errors.As(err, &retrieveErr) || retrieveErr.ErrorCode != "invalid_client" {
+		return
+	}
+	if client.endpoint == "" {
+		return
+	}
+	c := m.connections[name]
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client != client {
+		return
+	}
+	_ = m.tokens.DeleteClient(ctx, client.endpoint)
+	c.client = nil
+}
+
+// registerClient returns the client the gateway registered at the
+// authorization server, registering it on first use. Replicas share one
+// registration.
+func (m *Manager) registerClient(ctx context.Context, endpoint, clientName string) (*registeredClient, error) {
+	var client registeredClient
+	saved, err := m.tokens.Client(ctx, endpoint)
+	if err == nil {
+		if err := json.Unmarshal(saved, &client); err != nil {
+			return nil, err
+		}
+		return &client, nil
+	}
+	if !errors.Is(err, tokenstore.ErrNotFound) {
+		return nil, err
+	}
+
+	reqJSON, err := json.Marshal(map[string]any{
+		"client_name":                clientName,
+		"redirect_uris":              []string{m.redirectURI()},
+		"grant_types":                []string{"authorization_code", "refresh_token"},
+		"response_types":             []string{"code"},
+		"token_endpoint_auth_method": "client_secret_basic",
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(reqJSON))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if err := doJSON(req, &client); err != nil {
+		return nil, fmt.Errorf("failed to register client at %s: %w", endpoint, err)
+	}
+	if client.ClientID == "" {
+		return nil, fmt.Errorf("failed to register client at %s: no client_id", endpoint)
+	}
+	clientJSON, err := json.Marshal(&client)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := m.tokens.SaveClient(ctx, endpoint, clientJSON)
+	if err != nil {
+		return nil, err
+	}
+	if !stored {
+		// Another replica registered at the same time
+		return m.registerClient(ctx, endpoint, clientName)
+	}
+	return &client, nil
+}
+
+// fetchResourceMetadata reads the protected resource metadata from
+// metadataURL, or from the well-known URLs of target (RFC 9728 section 3.1).
+func fetchResourceMetadata(ctx context.Context, metadataURL string, target *url.URL) (*resourceMetadata, error) {
+	candidates := []string{metadataURL}
+	if metadataURL == "" {
+		origin := target.Scheme + "://" + target.Host
+		candidates = nil
+		if path := strings.TrimSuffix(target.EscapedPath(), "/"); path != "" {
+			candidates = append(candidates, origin+"/.well-known/oauth-protected-resource"+path)
+		}
+		candidates = append(candidates, origin+"/.well-known/oauth-protected-resource")
+	}
+	var errs []error
+	for _, u := range candidates {
+		var prm resourceMetadata
+		if err := getJSON(ctx, u, &prm); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if len(prm.AuthorizationServers) == 0 {
+			return nil, fmt.Errorf("protected resource metadata %s has no authorization servers", u)
+		}
+		return &prm, nil
+	}
+	return nil, fmt.Errorf("failed to discover protected resource metadata: %w", errors.Join(errs...))
+}
+
+// fetchAuthorizationServerMetadata reads the metadata of issuer from the
+// well-known URLs of RFC 8414 and OpenID Connect Discovery.
+func fetchAuthorizationServerMetadata(ctx context.Context, issuer string) (*authorizationServerMetadata, error) {
+	u, err := url.Parse(issuer)
+	if err != nil || !u.IsAbs() {
+		return nil, fmt.Errorf("invalid authorization server: %q", issuer)
+	}
+	origin := u.Scheme + "://" + u.Host
+	path := strings.TrimSuffix(u.EscapedPath(), "/")
+	candidates := []string{
+		origin + "/.well-known/oauth-authorization-server" + path,
+		origin + "/.well-known/openid-configuration" + path,
+	}
+	if path != "" {
+		candidates = append(candidates, origin+path+"/.well-known/openid-configuration")
+	}
+	var errs []error
+	for _, c := range candidates {
+		var asm authorizationServerMetadata
+		if err := getJSON(ctx, c, &asm); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if strings.TrimSuffix(asm.Issuer, "/") != strings.TrimSuffix(issuer, "/") {
+			return nil, fmt.Errorf("authorization server metadata %s has issuer %q, expected %q", c, asm.Issuer, issuer)
+		}
+		if asm.AuthorizationEndpoint == "" || asm.TokenEndpoint == "" {
+			return nil, fmt.Errorf("authorization server metadata %s has no authorization or token endpoint", c)
+		}
+		return &asm, nil
+	}
+	return nil, fmt.Errorf("failed to discover authorization server metadata: %w", errors.Join(errs...))
+}
+
+func getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	return doJSON(req, v)
+}
+
+func doJSON(req *http.Request, v any) error {
+	resp, err := discoveryClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxMetadataSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL, resp.Status, bytes.TrimSpace(body))
+	}
+	return json.Unmarshal(body, v)
+}
+
+// resourceTransport adds the resource parameter to token requests, which
+// golang.org/x/oauth2 cannot send when it refreshes a token.
+type resourceTransport struct {
+	resource string
+	base     http.RoundTripper
+}
+
+func (t *resourceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPost || req.Body == nil || !strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		return t.base.RoundTrip(req)
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	form.Set("resource", t.resource)
+	encoded := form.Encode()
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(strings.NewReader(encoded))
+	req.ContentLength = int64(len(encoded))
+	return t.base.RoundTrip(req)
+}
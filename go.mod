@@ -3,13 +3,21 @@ module github.com/securemcp/securemcp-okta-gateway
 go 1.24.3
 
 require (
+	github.com/go-jose/go-jose/v4 v4.0.5
 	github.com/goccy/go-yaml v1.17.1
+	github.com/google/cel-go v0.26.1
 	github.com/redis/go-redis/v9 v9.8.0
 )
 
 require (
-	github.com/go-jose/go-jose/v4 v4.0.5 // indirect
+	cel.dev/expr v0.24.0 // indirect
+	github.com/antlr4-go/antlr/v4 v4.13.0 // indirect
+	github.com/stoewer/go-strcase v1.2.0 // indirect
 	golang.org/x/crypto v0.36.0 // indirect
+	golang.org/x/exp v0.0.0-20230515195305-f3d0a9c9a5cc // indirect
+	google.golang.org/genproto/googleapis/api v0.0.0-20240826202546-f6391c0de4c7 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240826202546-f6391c0de4c7 // indirect
+	google.golang.org/protobuf v1.34.2 // indirect
 )
 
 require (
@@ -0,0 +1,200 @@
+package handler
+
+import (
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+
+	"github.com/securemcp/securemcp-okta-gateway/connections"
+	"github.com/securemcp/securemcp-okta-gateway/logging"
+	"github.com/securemcp/securemcp-okta-gateway/provider"
+)
+
+var connectTemplate = template.Must(template.New("connect").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Connect {{.DisplayName}}</title>
+</head>
+<body>
+<h1>Connect {{.DisplayName}}</h1>
+<p>MCP servers will use your {{.DisplayName}} account on behalf of {{if .Email}}<strong>{{.Email}}</strong>{{else}}your account{{end}}. Continue only if you asked for this. You will sign in to confirm that it is you.</p>
+<form method="post">
+<input type="hidden" name="ticket" value="{{.Ticket}}">
+<button type="submit">Connect</button>
+</form>
+</body>
+</html>
+`))
+
+var connectedTemplate = template.Must(template.New("connected").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.}} connected</title>
+</head>
+<body>
+<h1>{{.}} connected</h1>
+<p>You can close this window and retry in your MCP client.</p>
+</body>
+</html>
+`))
+
+type connectPage struct {
+	DisplayName string
+	Email       string
+	Ticket      string
+}
+
+// ConnectionConnect asks the user to confirm the connection named in the
+// ticket and then has them sign in, see connectSignedIn.
+func (h *Handler) ConnectionConnect(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logging.FromContext(ctx).With(
+		slog.String("handler", "ConnectionConnect"),
+		slog.String("request_id", ctx.Value(logging.RequestIDKey).(string)),
+	)
+	ctx = logging.WithContext(ctx, log)
+
+	if h.connections == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		ticket := r.URL.Query().Get("ticket")
+		t, err := h.connections.GetTicket(ctx, ticket)
+		if err != nil {
+			log.Info("Unknown connect ticket", "error", err)
+			http.Error(w, "The link expired, retry in your MCP client to get a new one", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := connectTemplate.Execute(w, &connectPage{
+			DisplayName: h.connections.DisplayName(t.Connection),
+			Email:       t.Email,
+			Ticket:      ticket,
+		}); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	case http.MethodPost:
+		sid := h.middleware.GetSid(ctx)
+		if sid == "" {
+			log.Error("Failed to get session ID")
+			http.Error(w, "Failed to get session ID", http.StatusInternalServerError)
+			return
+		}
+		t, err := h.connections.StartLogin(ctx, sid, r.FormValue("ticket"))
+		if err != nil {
+			log.Info("Failed to start connection", "error", err)
+			http.Error(w, "The link expired, retry in your MCP client to get a new one", http.StatusBadRequest)
+			return
+		}
+		idp := h.providers.ProviderOf(t.UID)
+		authCodeURL, err := h.providers.GetAuthCodeURL(ctx, sid, idp, t.Email, provider.PurposeConnect)
+		if err != nil {
+			log.Error("Failed to get auth code URL", "error", err)
+			http.Error(w, "Failed to get auth code URL", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, authCodeURL, http.StatusFound)
+	default:
+		log.Error("Invalid request method", "method", r.Method)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]any{
+			"error":             "invalid_request",
+			"error_description": "Only GET and POST are supported for this endpoint.",
+		})
+	}
+}
+
+// connectSignedIn continues the connection once the user signed in to the
+// gateway, and sends them to the third-party provider if they are the user
+// the connect link was issued to.
+func (h *Handler) connectSignedIn(w http.ResponseWriter, r *http.Request, sid, uid string) {
+	ctx := r.Context()
+	log := logging.FromContext(ctx)
+
+	if h.connections == nil {
+		http.NotFound(w, r)
+		return
+	}
+	authCodeURL, t, err := h.connections.AuthCodeURL(ctx, sid, uid)
+	if errors.Is(err, connections.ErrWrongUser) {
+		log.Warn("Connect link opened by another user",
+			slog.String("event", "security"),
+			slog.String("uid", uid),
+			slog.String("ticket_uid", t.UID),
+			slog.String("connection", t.Connection),
+		)
+		http.Error(w, "This link was issued to another user, retry in your own MCP client", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Info("Failed to start connection", "error", err)
+		http.Error(w, "The link expired, retry in your MCP client to get a new one", http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, authCodeURL, http.StatusFound)
+}
+
+// ConnectionCallback stores the tokens of the account the user connected.
+func (h *Handler) ConnectionCallback(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logging.FromContext(ctx).With(
+		slog.String("handler", "ConnectionCallback"),
+		slog.String("request_id", ctx.Value(logging.RequestIDKey).(string)),
+	)
+	ctx = logging.WithContext(ctx, log)
+
+	if h.connections == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		sid := h.middleware.GetSid(ctx)
+		if sid == "" {
+			log.Error("Failed to get session ID")
+			http.Error(w, "Failed to get session ID", http.StatusInternalServerError)
+			return
+		}
+		if errCode := r.URL.Query().Get("error"); errCode != "" {
+			log.Info("Connection denied", "error", errCode, "error_description", r.URL.Query().Get("error_description"))
+			http.Error(w, "The connection was not authorized", http.StatusForbidden)
+			return
+		}
+		t, err := h.connections.Callback(ctx, sid, r.URL.Query().Get("state"), r.URL.Query().Get("code"))
+		if errors.Is(err, connections.ErrWrongUser) {
+			log.Warn("Connection callback for another user",
+				slog.String("event", "security"),
+				slog.String("ticket_uid", t.UID),
+				slog.String("connection", t.Connection),
+			)
+			http.Error(w, "This link was issued to another user, retry in your own MCP client", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			log.Error("Failed to connect account", "error", err)
+			http.Error(w, "Failed to connect account", http.StatusInternalServerError)
+			return
+		}
+		log.Info("Connected account", "uid", t.UID, "connection", t.Connection)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := connectedTemplate.Execute(w, h.connections.DisplayName(t.Connection)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	default:
+		log.Error("Invalid request method", "method", r.Method)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]any{
+			"error":             "invalid_request",
+			"error_description": "Only GET is supported for this endpoint.",
+		})
+	}
+}
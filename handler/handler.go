@@ -7,38 +7,89 @@ import (
 	"github.com/redis/go-redis/v9"
 	"github.com/securemcp/securemcp-okta-gateway/auth"
 	"github.com/securemcp/securemcp-okta-gateway/config"
+	"github.com/securemcp/securemcp-okta-gateway/connections"
 	"github.com/securemcp/securemcp-okta-gateway/middleware"
+	"github.com/securemcp/securemcp-okta-gateway/provider"
+	"github.com/securemcp/securemcp-okta-gateway/provider/oidc"
 	"github.com/securemcp/securemcp-okta-gateway/provider/okta"
+	"github.com/securemcp/securemcp-okta-gateway/proxy"
+	"github.com/securemcp/securemcp-okta-gateway/tokenstore"
 )
 
 type Handler struct {
-	baseURL    string
-	auth       *auth.Auth
-	middleware *middleware.Middleware
-	oauthOkta  *okta.OktaProvider
+	baseURL     string
+	auth        *auth.Auth
+	middleware  *middleware.Middleware
+	providers   *provider.Registry
+	proxies     config.Proxies
+	services    []*proxy.ProxyService
+	tokens      *tokenstore.Store    // nil when upstream tokens are not stored
+	connections *connections.Manager // nil when upstream tokens are not stored
+	rdb         *redis.Client
 }
 
 func NewHandler(
 	ctx context.Context,
 	rdb *redis.Client,
 	config *config.Config,
+	proxies config.Proxies,
+	services []*proxy.ProxyService,
 	auth *auth.Auth,
+	tokens *tokenstore.Store,
+	connections *connections.Manager,
 	middleware *middleware.Middleware,
 ) (*Handler, error) {
-	oauthOkta, err := okta.NewOktaProvider(ctx, &okta.OktaConfig{
-		OktaURL:          config.OAuthOktaConfig.OktaURL,
-		OktaClientID:     config.OAuthOktaConfig.OktaClientID,
-		OktaClientSecret: config.OAuthOktaConfig.OktaClientSecret,
-		OktaRedirectURI:  config.OAuthOktaConfig.OktaRedirectURI,
-	}, rdb)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create oauth okta provider: %w", err)
+	providers := provider.NewRegistry(rdb)
+	for _, c := range config.IdentityProviders {
+		idp, err := newProvider(ctx, c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s identity provider %s: %w", c.Type, c.Name, err)
+		}
+		if err := providers.Register(idp, c.Domains); err != nil {
+			return nil, err
+		}
+		if tokens != nil {
+			tokens.Register(idp.Name(), idp.OAuth2Config(), nil)
+		}
 	}
 
 	return &Handler{
-		baseURL:    config.BaseURL,
-		auth:       auth,
-		middleware: middleware,
-		oauthOkta:  oauthOkta,
+		baseURL:     config.BaseURL,
+		auth:        auth,
+		middleware:  middleware,
+		providers:   providers,
+		proxies:     proxies,
+		services:    services,
+		tokens:      tokens,
+		connections: connections,
+		rdb:         rdb,
 	}, nil
 }
+
+func newProvider(ctx context.Context, config *config.IdentityProviderConfig) (provider.Provider, error) {
+	switch config.Type {
+	case "oidc":
+		return oidc.NewOIDCProvider(ctx, &oidc.OIDCConfig{
+			Name:         config.Name,
+			DisplayName:  config.DisplayName,
+			IssuerURL:    config.IssuerURL,
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			RedirectURI:  config.RedirectURI,
+			Scopes:       config.Scopes,
+		})
+	default:
+		return okta.NewOktaProvider(ctx, &okta.OktaConfig{
+			Name:             config.Name,
+			DisplayName:      config.DisplayName,
+			OktaURL:          config.OktaURL,
+			OktaClientID:     config.ClientID,
+			OktaClientSecret: config.ClientSecret,
+			OktaRedirectURI:  config.RedirectURI,
+			Scopes:           config.Scopes,
+
+			AuthorizationServerID: config.AuthorizationServerID,
+			OktaAPIToken:          config.OktaAPIToken,
+		})
+	}
+}
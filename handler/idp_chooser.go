@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"html/template"
+	"net/http"
+)
+
+var providerChooserTemplate = template.Must(template.New("chooser").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Sign in</title>
+</head>
+<body>
+<h1>Sign in with</h1>
+<ul>
+{{range .}}<li><a href="{{.URL}}">{{.DisplayName}}</a></li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+type providerChoice struct {
+	DisplayName string
+	URL         string
+}
+
+// renderProviderChooser lets the user pick an identity provider when home-realm
+// discovery found none. Every link repeats the authorization request with idp set.
+func (h *Handler) renderProviderChooser(w http.ResponseWriter, r *http.Request) {
+	choices := []providerChoice{}
+	for _, p := range h.providers.Providers() {
+		q := r.URL.Query()
+		q.Set("idp", p.Name())
+		u := *r.URL
+		u.RawQuery = q.Encode()
+		choices = append(choices, providerChoice{
+			DisplayName: p.DisplayName(),
+			URL:         u.String(),
+		})
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := providerChooserTemplate.Execute(w, choices); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
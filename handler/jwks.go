@@ -0,0 +1,13 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/securemcp/securemcp-okta-gateway/keys"
+)
+
+func (h *Handler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(keys.JWKSMaxAge.Seconds())))
+	writeJSON(w, http.StatusOK, h.auth.GetJWKS())
+}
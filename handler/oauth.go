@@ -8,6 +8,8 @@ import (
 
 	"github.com/securemcp/securemcp-okta-gateway/auth"
 	"github.com/securemcp/securemcp-okta-gateway/logging"
+	"github.com/securemcp/securemcp-okta-gateway/provider"
+	"github.com/securemcp/securemcp-okta-gateway/tokenstore"
 )
 
 func (h *Handler) OAuthRegister(w http.ResponseWriter, r *http.Request) {
@@ -89,6 +91,8 @@ func (h *Handler) OAuthAuthorize(w http.ResponseWriter, r *http.Request) {
 			State:               q.Get("state"),
 			CodeChallenge:       q.Get("code_challenge"),
 			CodeChallengeMethod: q.Get("code_challenge_method"),
+			Scope:               q.Get("scope"),
+			Resource:            q.Get("resource"),
 		}
 		client, authErr := h.auth.GetClient(ctx, params.ClientID)
 		if authErr != nil {
@@ -111,7 +115,26 @@ func (h *Handler) OAuthAuthorize(w http.ResponseWriter, r *http.Request) {
 			HandleAuthError(w, r, authErr)
 			return
 		}
-		authCode, err := h.oauthOkta.GetAuthCodeURL(ctx, sid)
+		idpHint, loginHint := q.Get("idp"), q.Get("login_hint")
+		idp, ok := h.providers.Discover(idpHint, loginHint)
+		if !ok && idpHint != "" {
+			log.Error("Unknown identity provider", "idp", idpHint)
+			HandleAuthError(w, r, &auth.AuthError{
+				AuthRedirectError: auth.AuthRedirectError{
+					RedirectURI:      params.RedirectURI,
+					ErrorCode:        auth.InvalidRequest,
+					ErrorDescription: "unknown idp",
+					State:            params.State,
+				},
+			})
+			return
+		}
+		if !ok {
+			h.renderProviderChooser(w, r)
+			return
+		}
+		log.Info("Selected identity provider", "idp", idp.Name())
+		authCode, err := h.providers.GetAuthCodeURL(ctx, sid, idp, loginHint, provider.PurposeAuthorize)
 		if err != nil {
 			log.Error("Failed to get auth code URL", "error", err)
 			http.Error(w, "Failed to get auth code URL", http.StatusInternalServerError)
@@ -145,13 +168,26 @@ func (h *Handler) OAuthCallback(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		claims, err := h.oauthOkta.Callback(ctx, sid, r.URL.Query().Get("state"), r.URL.Query().Get("code"))
+		claims, purpose, err := h.providers.Callback(ctx, sid, r.URL.Query().Get("state"), r.URL.Query().Get("code"))
 		if err != nil {
 			log.Error("Failed to get user ID", "error", err)
 			http.Error(w, "Failed to get user ID", http.StatusInternalServerError)
 			return
 		}
 
+		if h.tokens != nil && claims.Token != nil {
+			if err := h.tokens.Save(ctx, tokenstore.LoginKey(claims.UID), claims.Provider, claims.Token); err != nil {
+				log.Error("Failed to save upstream token", "error", err)
+				http.Error(w, "Failed to save upstream token", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if purpose == provider.PurposeConnect {
+			h.connectSignedIn(w, r, sid, claims.UID)
+			return
+		}
+
 		authParams, authErr := h.auth.GetAuthorization(ctx, sid)
 		if authErr != nil {
 			log.Error("Failed to get authorization", "error", authErr)
@@ -160,10 +196,15 @@ func (h *Handler) OAuthCallback(w http.ResponseWriter, r *http.Request) {
 		}
 
 		authCode, authErr := h.auth.GenerateAuthorizationCode(ctx, &auth.AuthorizationCodeParams{
-			UID:           claims.Sub,
+			UID:           claims.UID,
+			Email:         claims.Email,
+			Groups:        claims.Groups,
+			Amr:           claims.Amr,
 			ClientID:      authParams.ClientID,
 			RedirectURI:   authParams.RedirectURI,
 			CodeChallenge: authParams.CodeChallenge,
+			Scopes:        strings.Fields(authParams.Scope),
+			Resource:      authParams.Resource,
 		})
 		if authErr != nil {
 			log.Error("Failed to generate authorization code", "error", authErr)
@@ -214,6 +255,7 @@ func (h *Handler) OAuthToken(w http.ResponseWriter, r *http.Request) {
 				ClientID:      r.FormValue("client_id"),
 				CodeVerifier:  r.FormValue("code_verifier"),
 				Authorization: r.Header.Get("Authorization"),
+				Resource:      r.FormValue("resource"),
 			}
 			if authErr := h.auth.TokenValidateParams(ctx, params); authErr != nil {
 				log.Error("Failed to validate token params", "error", authErr)
@@ -236,19 +278,34 @@ func (h *Handler) OAuthToken(w http.ResponseWriter, r *http.Request) {
 				HandleAuthError(w, r, authErr)
 				return
 			}
-			uid, authErr := h.auth.VerifyAuthorizationCode(ctx, params.Code, params.ClientID, params.RedirectURI, params.CodeVerifier)
+			codeParams, authErr := h.auth.VerifyAuthorizationCode(ctx, params.Code, params.ClientID, params.RedirectURI, params.CodeVerifier)
 			if authErr != nil {
 				log.Error("Failed to verify authorization code", "error", authErr)
 				HandleAuthError(w, r, authErr)
 				return
 			}
-			accessToken, authErr := h.auth.GenerateAccessToken(ctx, uid)
+			resource, authErr := h.auth.ResolveTokenResource(params.Resource, codeParams.Resource)
+			if authErr != nil {
+				log.Error("Failed to resolve resource", "error", authErr)
+				HandleAuthError(w, r, authErr)
+				return
+			}
+			record := &auth.TokenRecord{
+				UID:      codeParams.UID,
+				Email:    codeParams.Email,
+				Groups:   codeParams.Groups,
+				Amr:      codeParams.Amr,
+				ClientID: client.ClientID,
+				Scopes:   codeParams.Scopes,
+				Resource: resource,
+			}
+			accessToken, authErr := h.auth.GenerateAccessToken(ctx, record)
 			if authErr != nil {
 				log.Error("Failed to generate access token", "error", authErr)
 				HandleAuthError(w, r, authErr)
 				return
 			}
-			refreshToken, authErr := h.auth.GenerateRefreshToken(ctx, uid)
+			refreshToken, authErr := h.auth.GenerateRefreshToken(ctx, record)
 			if authErr != nil {
 				log.Error("Failed to generate refresh token", "error", authErr)
 				HandleAuthError(w, r, authErr)
@@ -259,6 +316,7 @@ func (h *Handler) OAuthToken(w http.ResponseWriter, r *http.Request) {
 				"expires_in":    3600,
 				"access_token":  accessToken,
 				"refresh_token": refreshToken,
+				"scope":         record.Scope(),
 			})
 		case "refresh_token":
 			params := &auth.RefreshTokenRequestParams{
@@ -266,6 +324,8 @@ func (h *Handler) OAuthToken(w http.ResponseWriter, r *http.Request) {
 				RefreshToken: r.FormValue("refresh_token"),
 				ClientID:     r.FormValue("client_id"),
 				ClientSecret: r.FormValue("client_secret"),
+				Scope:        r.FormValue("scope"),
+				Resource:     r.FormValue("resource"),
 			}
 			if authErr := h.auth.RefreshTokenValidateParams(ctx, params); authErr != nil {
 				log.Error("Failed to validate refresh token params", "error", authErr)
@@ -283,13 +343,19 @@ func (h *Handler) OAuthToken(w http.ResponseWriter, r *http.Request) {
 				HandleAuthError(w, r, authErr)
 				return
 			}
-			uid, authErr := h.auth.VerifyRefreshToken(ctx, params.RefreshToken)
+			record, authErr := h.auth.RefreshTokenValidateGrant(ctx, params)
+			if authErr != nil {
+				log.Error("Failed to validate refresh token grant", "error", authErr)
+				HandleAuthError(w, r, authErr)
+				return
+			}
+			refreshToken, _, authErr := h.auth.RotateRefreshToken(ctx, params.RefreshToken, client.ClientID)
 			if authErr != nil {
-				log.Error("Failed to verify refresh token", "error", authErr)
+				log.Error("Failed to rotate refresh token", "error", authErr)
 				HandleAuthError(w, r, authErr)
 				return
 			}
-			accessToken, authErr := h.auth.GenerateAccessToken(ctx, uid)
+			accessToken, authErr := h.auth.GenerateAccessToken(ctx, record)
 			if authErr != nil {
 				log.Error("Failed to generate access token", "error", authErr)
 				HandleAuthError(w, r, authErr)
@@ -299,7 +365,50 @@ func (h *Handler) OAuthToken(w http.ResponseWriter, r *http.Request) {
 				"token_type":    "Bearer",
 				"expires_in":    3600,
 				"access_token":  accessToken,
-				"refresh_token": params.RefreshToken,
+				"refresh_token": refreshToken,
+				"scope":         record.Scope(),
+			})
+		case auth.GrantTypeTokenExchange:
+			resourceServer, authErr := h.auth.AuthenticateResourceServer(ctx, r.Header.Get("Authorization"))
+			if authErr != nil {
+				log.Error("Failed to authenticate resource server", "error", authErr)
+				HandleAuthError(w, r, authErr)
+				return
+			}
+			params := &auth.TokenExchangeRequestParams{
+				GrantType:          r.FormValue("grant_type"),
+				SubjectToken:       r.FormValue("subject_token"),
+				SubjectTokenType:   r.FormValue("subject_token_type"),
+				RequestedTokenType: r.FormValue("requested_token_type"),
+				Audience:           r.FormValue("audience"),
+				Resource:           r.FormValue("resource"),
+				Scope:              r.FormValue("scope"),
+			}
+			if authErr := h.auth.TokenExchangeValidateParams(ctx, params); authErr != nil {
+				log.Error("Failed to validate token exchange params", "error", authErr)
+				HandleAuthError(w, r, authErr)
+				return
+			}
+			record, authErr := h.auth.ExchangeToken(ctx, params, resourceServer)
+			if authErr != nil {
+				log.Error("Failed to exchange token", "resource_server", resourceServer, "error", authErr)
+				HandleAuthError(w, r, authErr)
+				return
+			}
+			accessToken, authErr := h.auth.GenerateAccessToken(ctx, record)
+			if authErr != nil {
+				log.Error("Failed to generate access token", "error", authErr)
+				HandleAuthError(w, r, authErr)
+				return
+			}
+			log.Info("Exchanged token", "uid", record.UID, "resource_server", resourceServer, "audience", record.Resource)
+			w.Header().Set("Cache-Control", "no-store")
+			writeJSON(w, http.StatusOK, map[string]any{
+				"token_type":        "Bearer",
+				"expires_in":        int(record.Lifetime().Seconds()),
+				"access_token":      accessToken,
+				"issued_token_type": auth.TokenTypeAccessToken,
+				"scope":             record.Scope(),
 			})
 		default:
 			log.Error("Unsupported grant type", "grant_type", r.FormValue("grant_type"))
@@ -319,3 +428,106 @@ func (h *Handler) OAuthToken(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 }
+
+func (h *Handler) OAuthRevoke(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logging.FromContext(ctx).With(
+		slog.String("handler", "OAuthRevoke"),
+		slog.String("request_id", ctx.Value(logging.RequestIDKey).(string)),
+	)
+	ctx = logging.WithContext(ctx, log)
+
+	switch r.Method {
+	case http.MethodPost:
+		if ct := r.Header.Get("Content-Type"); ct == "" || !strings.HasPrefix(ct, "application/x-www-form-urlencoded") {
+			log.Error("Invalid Content-Type", "content_type", ct)
+			writeJSON(w, http.StatusBadRequest, map[string]any{
+				"error":             "invalid_request",
+				"error_description": "Content-Type must be application/x-www-form-urlencoded",
+			})
+			return
+		}
+		params := &auth.RevocationRequestParams{
+			Token:         r.FormValue("token"),
+			TokenTypeHint: r.FormValue("token_type_hint"),
+			ClientID:      r.FormValue("client_id"),
+			ClientSecret:  r.FormValue("client_secret"),
+			Authorization: r.Header.Get("Authorization"),
+		}
+		if authErr := h.auth.RevocationValidateParams(ctx, params); authErr != nil {
+			log.Error("Failed to validate revocation params", "error", authErr)
+			HandleAuthError(w, r, authErr)
+			return
+		}
+		client, authErr := h.auth.AuthenticateClient(ctx, params.ClientID, params.ClientSecret, params.Authorization)
+		if authErr != nil {
+			log.Error("Failed to authenticate client", "error", authErr)
+			HandleAuthError(w, r, authErr)
+			return
+		}
+		if authErr := h.auth.RevokeToken(ctx, params, client); authErr != nil {
+			log.Error("Failed to revoke token", "error", authErr)
+			HandleAuthError(w, r, authErr)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		log.Error("Invalid request method", "method", r.Method)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]any{
+			"error":             "invalid_request",
+			"error_description": "Only POST is supported for this endpoint.",
+		})
+	}
+}
+
+func (h *Handler) OAuthIntrospect(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logging.FromContext(ctx).With(
+		slog.String("handler", "OAuthIntrospect"),
+		slog.String("request_id", ctx.Value(logging.RequestIDKey).(string)),
+	)
+	ctx = logging.WithContext(ctx, log)
+
+	switch r.Method {
+	case http.MethodPost:
+		if ct := r.Header.Get("Content-Type"); ct == "" || !strings.HasPrefix(ct, "application/x-www-form-urlencoded") {
+			log.Error("Invalid Content-Type", "content_type", ct)
+			writeJSON(w, http.StatusBadRequest, map[string]any{
+				"error":             "invalid_request",
+				"error_description": "Content-Type must be application/x-www-form-urlencoded",
+			})
+			return
+		}
+		params := &auth.IntrospectionRequestParams{
+			Token:         r.FormValue("token"),
+			TokenTypeHint: r.FormValue("token_type_hint"),
+			Authorization: r.Header.Get("Authorization"),
+		}
+		resourceServer, authErr := h.auth.AuthenticateResourceServer(ctx, params.Authorization)
+		if authErr != nil {
+			log.Error("Failed to authenticate resource server", "error", authErr)
+			HandleAuthError(w, r, authErr)
+			return
+		}
+		if authErr := h.auth.IntrospectionValidateParams(ctx, params); authErr != nil {
+			log.Error("Failed to validate introspection params", "error", authErr)
+			HandleAuthError(w, r, authErr)
+			return
+		}
+		resp, authErr := h.auth.IntrospectToken(ctx, params)
+		if authErr != nil {
+			log.Error("Failed to introspect token", "error", authErr)
+			HandleAuthError(w, r, authErr)
+			return
+		}
+		log.Info("Introspected token", "resource_server", resourceServer, "active", resp.Active)
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, resp)
+	default:
+		log.Error("Invalid request method", "method", r.Method)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]any{
+			"error":             "invalid_request",
+			"error_description": "Only POST is supported for this endpoint.",
+		})
+	}
+}
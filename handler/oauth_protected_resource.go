@@ -2,13 +2,55 @@ package handler
 
 import (
 	"net/http"
+	"strings"
+
+	"github.com/securemcp/securemcp-okta-gateway/config"
 )
 
+const protectedResourceMetadataPath = "/.well-known/oauth-protected-resource"
+
 func (h *Handler) OAuthProtectedResourceMetadata(w http.ResponseWriter, r *http.Request) {
-	writeJSON(w, http.StatusOK, map[string]any{
-		"resource":                              h.baseURL,
-		"issuer":                                h.baseURL,
-		"authorization_servers":                 []string{h.baseURL},
-		"token_endpoint_auth_methods_supported": h.auth.GetSupportTokenEndpointAuthMethods(),
-	})
+	if r.URL.Path == protectedResourceMetadataPath {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"resource":                              h.baseURL,
+			"issuer":                                h.baseURL,
+			"authorization_servers":                 []string{h.baseURL},
+			"token_endpoint_auth_methods_supported": h.auth.GetSupportTokenEndpointAuthMethods(),
+			"scopes_supported":                      h.auth.GetSupportScopes(),
+		})
+		return
+	}
+
+	p := h.findProxy(strings.TrimPrefix(r.URL.Path, protectedResourceMetadataPath))
+	if p == nil {
+		writeJSON(w, http.StatusNotFound, map[string]any{
+			"error":             "invalid_request",
+			"error_description": "Unknown protected resource",
+		})
+		return
+	}
+	metadata := map[string]any{
+		"resource":                 p.Resource,
+		"authorization_servers":    []string{h.baseURL},
+		"bearer_methods_supported": []string{"header"},
+		"scopes_supported":         p.Scopes,
+	}
+	if p.ResourceName != "" {
+		metadata["resource_name"] = p.ResourceName
+	}
+	if p.ResourceDocumentation != "" {
+		metadata["resource_documentation"] = p.ResourceDocumentation
+	}
+	writeJSON(w, http.StatusOK, metadata)
+}
+
+// findProxy returns the proxy serving path, e.g. /mcp/dice or /mcp/dice/mcp
+// for the proxy with pattern /mcp/dice/.
+func (h *Handler) findProxy(path string) *config.ProxyConfig {
+	for _, p := range h.proxies {
+		if path == strings.TrimSuffix(p.Pattern, "/") || strings.HasPrefix(path, p.Pattern) {
+			return p
+		}
+	}
+	return nil
 }
@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/securemcp/securemcp-okta-gateway/logging"
+	"github.com/securemcp/securemcp-okta-gateway/proxy"
+)
+
+type proxyStatus struct {
+	Pattern   string                  `json:"pattern"`
+	Available bool                    `json:"available"`
+	Upstreams []*proxy.UpstreamStatus `json:"upstreams"`
+}
+
+// Readyz reports whether the gateway can serve requests, which only depends
+// on Redis. Upstreams are reported for information: one failing backend must
+// not take every replica of the gateway out of rotation.
+func (h *Handler) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logging.FromContext(ctx).With(
+		slog.String("handler", "Readyz"),
+		slog.String("request_id", ctx.Value(logging.RequestIDKey).(string)),
+	)
+
+	status, code := "ok", http.StatusOK
+	redisStatus := "ok"
+	pingCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if err := h.rdb.Ping(pingCtx).Err(); err != nil {
+		log.Error("Failed to ping redis", "error", err)
+		redisStatus = "unavailable"
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+
+	proxies := make([]*proxyStatus, 0, len(h.services))
+	for _, s := range h.services {
+		upstreams, available := s.Status()
+		proxies = append(proxies, &proxyStatus{
+			Pattern:   s.Pattern(),
+			Available: available,
+			Upstreams: upstreams,
+		})
+	}
+
+	writeJSON(w, code, map[string]any{
+		"status":  status,
+		"redis":   redisStatus,
+		"proxies": proxies,
+	})
+}
@@ -25,6 +25,19 @@ func HandleAuthError(w http.ResponseWriter, r *http.Request, err *auth.AuthError
 		if err.AuthJsonError.Code == auth.InvalidRequest {
 			status = http.StatusBadRequest
 		}
+		if err.AuthJsonError.Code == auth.InvalidTarget {
+			status = http.StatusBadRequest
+		}
+		if err.AuthJsonError.Code == auth.InvalidScope {
+			status = http.StatusBadRequest
+		}
+		if err.AuthJsonError.Code == auth.InvalidGrant {
+			status = http.StatusBadRequest
+		}
+		if err.AuthJsonError.Code == auth.InvalidClient {
+			status = http.StatusUnauthorized
+			w.Header().Set("WWW-Authenticate", `Basic realm="securemcp"`)
+		}
 		if err.AuthJsonError.Code == auth.UnauthorizedClient {
 			status = http.StatusUnauthorized
 		}
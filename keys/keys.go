@@ -0,0 +1,114 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+const (
+	RS256 = string(jose.RS256)
+	ES256 = string(jose.ES256)
+)
+
+type Key struct {
+	jwk jose.JSONWebKey
+}
+
+func Generate(alg string) (*Key, error) {
+	var privateKey crypto.Signer
+	var err error
+	switch alg {
+	case RS256:
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case ES256:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", alg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	return newKey(privateKey, alg)
+}
+
+func LoadPEM(path, alg string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode pem: %s", path)
+	}
+
+	var parsed any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported pem block type: %s", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %w", err)
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		if alg != RS256 {
+			return nil, fmt.Errorf("rsa key cannot be used with %s", alg)
+		}
+		return newKey(k, alg)
+	case *ecdsa.PrivateKey:
+		if alg != ES256 || k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("ec key must be P-256 and used with ES256")
+		}
+		return newKey(k, alg)
+	default:
+		return nil, fmt.Errorf("unsupported private key type: %T", parsed)
+	}
+}
+
+func newKey(privateKey crypto.Signer, alg string) (*Key, error) {
+	jwk := jose.JSONWebKey{
+		Key:       privateKey,
+		Algorithm: alg,
+		Use:       "sig",
+	}
+	thumbprint, err := jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute key thumbprint: %w", err)
+	}
+	jwk.KeyID = base64.RawURLEncoding.EncodeToString(thumbprint)
+	return &Key{jwk: jwk}, nil
+}
+
+func (k *Key) KeyID() string {
+	return k.jwk.KeyID
+}
+
+func (k *Key) Algorithm() jose.SignatureAlgorithm {
+	return jose.SignatureAlgorithm(k.jwk.Algorithm)
+}
+
+func (k *Key) Public() jose.JSONWebKey {
+	return k.jwk.Public()
+}
+
+func (k *Key) Signer(typ string) (jose.Signer, error) {
+	opts := (&jose.SignerOptions{}).WithType(jose.ContentType(typ))
+	return jose.NewSigner(jose.SigningKey{Algorithm: k.Algorithm(), Key: k.jwk}, opts)
+}
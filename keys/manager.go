@@ -0,0 +1,350 @@
+package keys
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/redis/go-redis/v9"
+	"github.com/securemcp/securemcp-okta-gateway/kvs"
+	"github.com/securemcp/securemcp-okta-gateway/logging"
+)
+
+const (
+	keySetKey       = "keyset"
+	rotationLockKey = "rotation_lock"
+	reloadInterval  = time.Minute
+	// JWKSMaxAge is how long clients may cache the JWKS
+	JWKSMaxAge = 5 * time.Minute
+	// A new key is published this long before it signs, so that every
+	// replica has reloaded it and no cached JWKS lacks it
+	publishLead = reloadInterval + JWKSMaxAge
+)
+
+type ManagerConfig struct {
+	Alg              string
+	KeyFile          string
+	PreviousKeyFiles []string
+	RotationInterval time.Duration
+	// Base64 AES-256 key encrypting generated keys in the kvs, unset stores them in plaintext
+	EncryptionKey string
+}
+
+// Manager holds the gateway's signing keys. Keys loaded from PEM files are
+// static; otherwise keys are generated, shared between replicas through the
+// kvs layer and rotated every RotationInterval.
+type Manager struct {
+	mu               sync.RWMutex
+	alg              string
+	rotationInterval time.Duration
+	static           bool
+	current          *Key
+	previous         []*Key
+	next             *Key // published, signs after the next rotation
+	aead             cipher.AEAD
+	keySetKVS        *kvs.KVS // key: keyset, value: key set
+	lockKVS          *kvs.KVS // key: rotation_lock, value: lock
+}
+
+type keySet struct {
+	Keys        []jose.JSONWebKey `json:"keys"`
+	RotatedAt   int64             `json:"rotated_at"`
+	Next        *jose.JSONWebKey  `json:"next,omitempty"`
+	PublishedAt int64             `json:"published_at,omitempty"` // of next
+}
+
+func NewManager(ctx context.Context, config *ManagerConfig, rdb *redis.Client) (*Manager, error) {
+	m := &Manager{
+		alg:              config.Alg,
+		rotationInterval: config.RotationInterval,
+		keySetKVS:        kvs.NewKVS(rdb, "signing_keys", kvs.SigningKeysTTL),
+		lockKVS:          kvs.NewKVS(rdb, "signing_keys", kvs.OAuthStateTTL),
+	}
+	if config.EncryptionKey != "" {
+		k, err := base64.StdEncoding.DecodeString(config.EncryptionKey)
+		if err != nil || len(k) != 32 {
+			return nil, fmt.Errorf("signing keys encryption key must be 32 bytes encoded in base64")
+		}
+		block, err := aes.NewCipher(k)
+		if err != nil {
+			return nil, err
+		}
+		if m.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.KeyFile != "" {
+		current, err := LoadPEM(config.KeyFile, config.Alg)
+		if err != nil {
+			return nil, err
+		}
+		previous := make([]*Key, 0, len(config.PreviousKeyFiles))
+		for _, f := range config.PreviousKeyFiles {
+			k, err := LoadPEM(f, config.Alg)
+			if err != nil {
+				return nil, err
+			}
+			previous = append(previous, k)
+		}
+		m.static = true
+		m.current = current
+		m.previous = previous
+		return m, nil
+	}
+
+	if config.RotationInterval < 2*kvs.OAuthAccessTokenTTL+publishLead {
+		return nil, fmt.Errorf("signing key rotation interval must be at least %s", 2*kvs.OAuthAccessTokenTTL+publishLead)
+	}
+	var err error
+	for range 5 {
+		if err = m.reload(ctx); err == nil {
+			return m, nil
+		}
+		time.Sleep(time.Second)
+	}
+	return nil, err
+}
+
+// Start rotates and reloads generated keys in the background until ctx is done.
+func (m *Manager) Start(ctx context.Context) {
+	if m.static {
+		return
+	}
+	log := logging.FromContext(ctx).With(
+		slog.String("keys", "Manager"),
+	)
+	go func() {
+		ticker := time.NewTicker(reloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := m.reload(ctx); err != nil {
+					log.Error("Failed to reload signing keys", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+func (m *Manager) SigningKey() *Key {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.current
+}
+
+func (m *Manager) VerificationKey(kid string) (*Key, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.current.KeyID() == kid {
+		return m.current, true
+	}
+	for _, k := range m.previous {
+		if k.KeyID() == kid {
+			return k, true
+		}
+	}
+	// Another replica may already have promoted the next key
+	if m.next != nil && m.next.KeyID() == kid {
+		return m.next, true
+	}
+	return nil, false
+}
+
+func (m *Manager) Algorithms() []jose.SignatureAlgorithm {
+	return []jose.SignatureAlgorithm{jose.SignatureAlgorithm(m.alg)}
+}
+
+// JWKS returns the public keys of the current, previous and next signing keys.
+func (m *Manager) JWKS() jose.JSONWebKeySet {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	set := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{m.current.Public()}}
+	for _, k := range m.previous {
+		set.Keys = append(set.Keys, k.Public())
+	}
+	if m.next != nil {
+		set.Keys = append(set.Keys, m.next.Public())
+	}
+	return set
+}
+
+func (m *Manager) reload(ctx context.Context) error {
+	set, err := m.load(ctx)
+	if err != nil {
+		return err
+	}
+	if m.due(set) {
+		set, err = m.rotate(ctx, set)
+		if err != nil {
+			return err
+		}
+	}
+
+	loaded := make([]*Key, 0, len(set.Keys))
+	for _, jwk := range set.Keys {
+		loaded = append(loaded, &Key{jwk: jwk})
+	}
+	var next *Key
+	if set.Next != nil {
+		next = &Key{jwk: *set.Next}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.current = loaded[0]
+	m.previous = loaded[1:]
+	m.next = next
+	return nil
+}
+
+// due reports whether the key set has to be created, needs its next key
+// published ahead of rotation, or the published next key has to sign.
+func (m *Manager) due(set *keySet) bool {
+	if set == nil {
+		return true
+	}
+	sinceRotation := time.Since(time.Unix(set.RotatedAt, 0))
+	if set.Next == nil {
+		return sinceRotation >= m.rotationInterval-publishLead
+	}
+	return sinceRotation >= m.rotationInterval && time.Since(time.Unix(set.PublishedAt, 0)) >= publishLead
+}
+
+func (m *Manager) load(ctx context.Context) (*keySet, error) {
+	value, err := m.keySetKVS.Get(ctx, keySetKey)
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get signing keys: %w", err)
+	}
+	setJSON := []byte(value)
+	// Key sets saved before encryption was enabled are read as they are
+	if m.aead != nil && !strings.HasPrefix(value, "{") {
+		if setJSON, err = m.open(value); err != nil {
+			return nil, err
+		}
+	}
+	var set keySet
+	if err := json.Unmarshal(setJSON, &set); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal signing keys: %w", err)
+	}
+	if len(set.Keys) == 0 {
+		return nil, nil
+	}
+	return &set, nil
+}
+
+// rotate publishes a new next key, or makes the next key the current one and
+// keeps the previous current key so that tokens signed before the rotation
+// can still be verified. Only one replica rotates at a time; the others pick
+// the new key set up on reload.
+func (m *Manager) rotate(ctx context.Context, set *keySet) (*keySet, error) {
+	locked, err := m.lockKVS.SetNX(ctx, rotationLockKey, "1")
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire rotation lock: %w", err)
+	}
+	if !locked {
+		if set == nil {
+			return nil, fmt.Errorf("signing keys are being generated by another replica")
+		}
+		return set, nil
+	}
+	defer m.lockKVS.Del(ctx, rotationLockKey)
+	// Another replica may have rotated since set was loaded
+	if set, err = m.load(ctx); err != nil {
+		return nil, err
+	}
+	if !m.due(set) {
+		return set, nil
+	}
+
+	log := logging.FromContext(ctx)
+	var rotated *keySet
+	switch {
+	case set == nil:
+		k, err := Generate(m.alg)
+		if err != nil {
+			return nil, err
+		}
+		rotated = &keySet{
+			Keys:      []jose.JSONWebKey{k.jwk},
+			RotatedAt: time.Now().Unix(),
+		}
+		log.Info("Generated signing key", "kid", k.KeyID())
+	case set.Next == nil:
+		k, err := Generate(m.alg)
+		if err != nil {
+			return nil, err
+		}
+		rotated = &keySet{
+			Keys:        set.Keys,
+			RotatedAt:   set.RotatedAt,
+			Next:        &k.jwk,
+			PublishedAt: time.Now().Unix(),
+		}
+		log.Info("Published next signing key", "kid", k.KeyID())
+	default:
+		rotated = &keySet{
+			Keys:      []jose.JSONWebKey{*set.Next, set.Keys[0]},
+			RotatedAt: time.Now().Unix(),
+		}
+		log.Info("Rotated signing key", "kid", set.Next.KeyID)
+	}
+	if err := m.save(ctx, rotated); err != nil {
+		return nil, err
+	}
+	return rotated, nil
+}
+
+func (m *Manager) save(ctx context.Context, set *keySet) error {
+	setJSON, err := json.Marshal(set)
+	if err != nil {
+		return fmt.Errorf("failed to marshal signing keys: %w", err)
+	}
+	value := string(setJSON)
+	if m.aead != nil {
+		if value, err = m.seal(setJSON); err != nil {
+			return fmt.Errorf("failed to encrypt signing keys: %w", err)
+		}
+	}
+	if err := m.keySetKVS.Set(ctx, keySetKey, value); err != nil {
+		return fmt.Errorf("failed to save signing keys: %w", err)
+	}
+	return nil
+}
+
+func (m *Manager) seal(plaintext []byte) (string, error) {
+	nonce := make([]byte, m.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(m.aead.Seal(nonce, nonce, plaintext, []byte(keySetKey))), nil
+}
+
+func (m *Manager) open(value string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(sealed) < m.aead.NonceSize() {
+		return nil, fmt.Errorf("malformed signing keys")
+	}
+	nonce, ciphertext := sealed[:m.aead.NonceSize()], sealed[m.aead.NonceSize():]
+	plaintext, err := m.aead.Open(nil, nonce, ciphertext, []byte(keySetKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt signing keys, check SIGNING_KEYS_ENCRYPTION_KEY: %w", err)
+	}
+	return plaintext, nil
+}
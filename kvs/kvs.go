@@ -14,6 +14,10 @@ const (
 	OAuthClientTTL         = 90 * 24 * time.Hour
 	SessionTTL             = 7 * 24 * time.Hour
 	ResourceAccessTokenTTL = 30 * 24 * time.Hour
+	MCPSessionTTL          = 24 * time.Hour
+	ConnectTicketTTL       = 15 * time.Minute
+	SigningKeysTTL         = 0 // no expiry
+	UpstreamClientTTL      = 0 // no expiry
 )
 
 type KVS struct {
@@ -42,6 +46,19 @@ func (k *KVS) Set(ctx context.Context, key string, value any) error {
 	return k.rdb.Set(ctx, k.prefix+key, value, k.ttl).Err()
 }
 
+// SetEX sets the key with a ttl other than the one of the store.
+func (k *KVS) SetEX(ctx context.Context, key string, value any, ttl time.Duration) error {
+	return k.rdb.Set(ctx, k.prefix+key, value, ttl).Err()
+}
+
+func (k *KVS) SetNX(ctx context.Context, key string, value any) (bool, error) {
+	return k.rdb.SetNX(ctx, k.prefix+key, value, k.ttl).Result()
+}
+
+func (k *KVS) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return k.rdb.TTL(ctx, k.prefix+key).Result()
+}
+
 func (k *KVS) Del(ctx context.Context, key string) error {
 	return k.rdb.Del(ctx, k.prefix+key).Err()
 }
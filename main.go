@@ -8,10 +8,14 @@ import (
 	"github.com/redis/go-redis/v9"
 	"github.com/securemcp/securemcp-okta-gateway/auth"
 	"github.com/securemcp/securemcp-okta-gateway/config"
+	"github.com/securemcp/securemcp-okta-gateway/connections"
 	"github.com/securemcp/securemcp-okta-gateway/handler"
+	"github.com/securemcp/securemcp-okta-gateway/keys"
 	"github.com/securemcp/securemcp-okta-gateway/logging"
 	"github.com/securemcp/securemcp-okta-gateway/middleware"
+	"github.com/securemcp/securemcp-okta-gateway/policy"
 	"github.com/securemcp/securemcp-okta-gateway/proxy"
+	"github.com/securemcp/securemcp-okta-gateway/tokenstore"
 )
 
 func main() {
@@ -30,34 +34,115 @@ func main() {
 		Password: config.KVSPassword,
 	})
 
+	// Create signing key manager, only when something is signed
+	var keyManager *keys.Manager
+	if config.AccessTokenFormat == auth.AccessTokenFormatJWT || proxies.IdentityAssertions() {
+		keyManager, err = keys.NewManager(ctx, &keys.ManagerConfig{
+			Alg:              config.SigningAlg,
+			KeyFile:          config.SigningKeyFile,
+			PreviousKeyFiles: config.SigningPreviousKeyFiles,
+			RotationInterval: config.SigningKeyRotationInterval,
+			EncryptionKey:    config.SigningKeysEncryptionKey,
+		}, rdb)
+		if err != nil {
+			log.Fatalf("failed to create key manager: %v", err)
+		}
+		keyManager.Start(ctx)
+	}
+
+	// Token exchange rules of every proxy
+	tokenExchange := []*auth.TokenExchangeRule{}
+	for _, p := range proxies {
+		if p.TokenExchange == nil {
+			continue
+		}
+		for _, a := range p.TokenExchange.Audiences {
+			tokenExchange = append(tokenExchange, &auth.TokenExchangeRule{
+				Resource:        p.Resource,
+				ResourceServers: p.TokenExchange.ResourceServers,
+				Audience:        a.Audience,
+				Scopes:          a.Scopes,
+			})
+		}
+	}
+
 	// Create Auth
-	auth := auth.NewAuth(config.BaseURL, rdb)
+	auth := auth.NewAuth(&auth.AuthConfig{
+		BaseURL:           config.BaseURL,
+		AccessTokenFormat: config.AccessTokenFormat,
+		Keys:              keyManager,
+		ResourceServers:   config.ResourceServers,
+		ScopesSupported:   proxies.ScopesSupported(),
+		DefaultScopes:     config.DefaultScopes,
+		Resources:         proxies.Resources(),
+		TokenExchange:     tokenExchange,
+	}, rdb)
+
+	// Create upstream token store
+	var tokens *tokenstore.Store
+	if config.UpstreamTokenKey != "" {
+		tokens, err = tokenstore.NewStore(rdb, config.UpstreamTokenKey)
+		if err != nil {
+			log.Fatalf("failed to create upstream token store: %v", err)
+		}
+	}
+
+	// Create connected accounts, backend MCP servers with their own authorization are connections too
+	var conns *connections.Manager
+	if tokens != nil {
+		conns = connections.NewManager(config.BaseURL, config.Connections, proxies, tokens, rdb)
+	}
 
 	// Create Middleware
-	m := middleware.NewMiddleware(auth)
+	m := middleware.NewMiddleware(auth, config.TrustForwardedFor, config.TrustedProxyHops)
+
+	// Create Proxy services
+	services := make([]*proxy.ProxyService, 0, len(proxies))
+	for _, p := range proxies {
+		service := proxy.NewProxyService(p, auth, tokens, conns, m, rdb)
+		service.Start(ctx)
+		services = append(services, service)
+	}
 
 	// Create Handler
-	h, err := handler.NewHandler(ctx, rdb, config, auth, m)
+	h, err := handler.NewHandler(ctx, rdb, config, proxies, services, auth, tokens, conns, m)
 	if err != nil {
 		log.Fatalf("failed to create handler: %v", err)
 	}
 
 	http.HandleFunc("/healthz", h.Healthz)
+	http.HandleFunc("/readyz", m.Logger(h.Readyz))
 
 	// Oauth Authorization Server for MCP Clients
 	http.HandleFunc("/.well-known/oauth-protected-resource", m.Logger(h.OAuthProtectedResourceMetadata))
+	http.HandleFunc("/.well-known/oauth-protected-resource/", m.Logger(h.OAuthProtectedResourceMetadata))
 	http.HandleFunc("/.well-known/oauth-authorization-server", m.Logger(h.OAuthAuthorizationServerMetadata))
+	http.HandleFunc("/.well-known/jwks.json", m.Logger(h.JWKS))
 	http.HandleFunc("/auth/register", m.Logger(h.OAuthRegister))
 	http.HandleFunc("/auth/authorize", m.Logger(m.SetSid(h.OAuthAuthorize)))
 	http.HandleFunc("/auth/callback", m.Logger(m.SetSid(h.OAuthCallback)))
 	http.HandleFunc("/auth/token", m.Logger(h.OAuthToken))
+	http.HandleFunc("/auth/revoke", m.Logger(h.OAuthRevoke))
+	http.HandleFunc("/auth/introspect", m.Logger(h.OAuthIntrospect))
+
+	// Connected accounts
+	http.HandleFunc("/connections/connect", m.Logger(m.SetSid(h.ConnectionConnect)))
+	http.HandleFunc("/connections/callback", m.Logger(m.SetSid(h.ConnectionCallback)))
 
 	// Create Proxy
-	for _, p := range proxies {
-		localProxy := proxy.NewProxyService(p, m)
-		http.HandleFunc(p.Pattern, m.Logger(m.MCPBearerToken(func(w http.ResponseWriter, r *http.Request) {
+	for i, p := range proxies {
+		localProxy := services[i]
+		next := m.Groups(p, func(w http.ResponseWriter, r *http.Request) {
 			localProxy.ServeHTTP(w, r)
-		})))
+		})
+		if p.Policy != nil {
+			evaluator, err := policy.NewEvaluator(p.Policy)
+			if err != nil {
+				log.Fatalf("failed to create policy for %s: %v", p.Pattern, err)
+			}
+			next = m.Policy(p, evaluator, next)
+		}
+		http.HandleFunc(p.Pattern, m.Logger(m.MCPBearerToken(p, next)))
 	}
 
 	logger.Info("Starting proxy server", "port", config.Port)
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/securemcp/securemcp-okta-gateway/config"
+	"github.com/securemcp/securemcp-okta-gateway/logging"
+)
+
+// Groups enforces the allowed_groups and denied_groups of a proxy. It must run
+// after MCPBearerToken. Denied groups take precedence over allowed groups.
+func (m *Middleware) Groups(proxy *config.ProxyConfig, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logging.FromContext(ctx).With(
+			slog.String("middleware", "Groups"),
+			slog.String("pattern", proxy.Pattern),
+		)
+
+		token := m.GetToken(ctx)
+		if token == nil {
+			log.Error("Failed to get token")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if token.InGroups(proxy.DeniedGroups) {
+			log.Info("User is in a denied group", "uid", token.UID, "groups", token.Groups)
+			forbidden(w, "The user is not allowed to access this resource")
+			return
+		}
+		if len(proxy.AllowedGroups) > 0 && !token.InGroups(proxy.AllowedGroups) {
+			log.Info("User is not in an allowed group", "uid", token.UID, "groups", token.Groups)
+			forbidden(w, "The user is not allowed to access this resource")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+func forbidden(w http.ResponseWriter, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error":             "access_denied",
+		"error_description": description,
+	})
+}
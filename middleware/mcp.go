@@ -2,28 +2,118 @@ package middleware
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"strings"
+
+	"github.com/securemcp/securemcp-okta-gateway/auth"
+	"github.com/securemcp/securemcp-okta-gateway/config"
+	"github.com/securemcp/securemcp-okta-gateway/logging"
+)
+
+const tokenKey contextKey = "token"
+
+// RFC 6750 error codes
+const (
+	bearerInvalidRequest    = "invalid_request"
+	bearerInvalidToken      = "invalid_token"
+	bearerInsufficientScope = "insufficient_scope"
 )
 
-func (m *Middleware) MCPBearerToken(next http.HandlerFunc) http.HandlerFunc {
+func (m *Middleware) MCPBearerToken(proxy *config.ProxyConfig, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
+		log := logging.FromContext(ctx).With(
+			slog.String("middleware", "MCPBearerToken"),
+			slog.String("pattern", proxy.Pattern),
+		)
+
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			bearerChallenge(w, proxy, http.StatusUnauthorized, "", "")
+			return
+		}
+		scheme, bearerToken, ok := strings.Cut(authHeader, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || bearerToken == "" {
+			log.Info("Malformed authorization header")
+			bearerChallenge(w, proxy, http.StatusBadRequest, bearerInvalidRequest, "Authorization header must use the Bearer scheme")
+			return
+		}
+
+		token, err := m.auth.VerifyAccessToken(ctx, bearerToken)
+		switch {
+		case errors.Is(err, auth.ErrExpiredAccessToken):
+			log.Info("Expired access token")
+			bearerChallenge(w, proxy, http.StatusUnauthorized, bearerInvalidToken, "The access token expired")
+			return
+		case errors.Is(err, auth.ErrInvalidAccessToken):
+			log.Info("Invalid access token")
+			bearerChallenge(w, proxy, http.StatusUnauthorized, bearerInvalidToken, "The access token is invalid")
+			return
+		case err != nil:
+			log.Error("Failed to verify access token", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		// Tokens issued without a resource indicator are valid for every proxy
+		if token.Resource != "" && token.Resource != proxy.Resource {
+			log.Info("Access token audience mismatch", "uid", token.UID, "resource", token.Resource)
+			bearerChallenge(w, proxy, http.StatusUnauthorized, bearerInvalidToken, "The access token was issued for another resource")
 			return
 		}
-		bearerToken := strings.TrimPrefix(authHeader, "Bearer ")
-		uid, err := m.auth.VerifyAccessToken(ctx, bearerToken)
-		if err != nil {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		if !token.HasScopes(proxy.Scopes) {
+			log.Info("Insufficient scope", "uid", token.UID, "scope", token.Scope())
+			bearerChallenge(w, proxy, http.StatusForbidden, bearerInsufficientScope, "The access token does not grant the required scope")
 			return
 		}
 
-		ctx = context.WithValue(ctx, uidKey, uid)
+		ctx = context.WithValue(ctx, uidKey, token.UID)
+		ctx = context.WithValue(ctx, tokenKey, token)
 		r = r.WithContext(ctx)
 
 		next.ServeHTTP(w, r)
 	}
 }
+
+// bearerChallenge writes an RFC 6750 WWW-Authenticate challenge that points
+// the client at the protected resource metadata (RFC 9728) of the proxy.
+func bearerChallenge(w http.ResponseWriter, proxy *config.ProxyConfig, status int, code, description string) {
+	params := []string{fmt.Sprintf("resource_metadata=%q", proxy.ResourceMetadataURL)}
+	if code != "" {
+		params = append(params, fmt.Sprintf("error=%q", code), fmt.Sprintf("error_description=%q", description))
+	}
+	if code == bearerInsufficientScope {
+		params = append(params, fmt.Sprintf("scope=%q", strings.Join(proxy.Scopes, " ")))
+	}
+	w.Header().Set("WWW-Authenticate", "Bearer "+strings.Join(params, ", "))
+	if code == "" {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+func (m *Middleware) GetToken(ctx context.Context) *auth.TokenRecord {
+	token, ok := ctx.Value(tokenKey).(*auth.TokenRecord)
+	if !ok {
+		return nil
+	}
+	return token
+}
+
+func (m *Middleware) GetClientID(ctx context.Context) string {
+	token := m.GetToken(ctx)
+	if token == nil {
+		return ""
+	}
+	return token.ClientID
+}
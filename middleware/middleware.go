@@ -1,17 +1,49 @@
 package middleware
 
 import (
+	"net"
+	"net/http"
+	"strings"
+
 	"github.com/securemcp/securemcp-okta-gateway/auth"
 )
 
 type Middleware struct {
-	auth *auth.Auth
+	auth              *auth.Auth
+	trustForwardedFor bool
+	trustedProxyHops  int
 }
 
-func NewMiddleware(auth *auth.Auth) *Middleware {
+func NewMiddleware(auth *auth.Auth, trustForwardedFor bool, trustedProxyHops int) *Middleware {
 	return &Middleware{
-		auth: auth,
+		auth:              auth,
+		trustForwardedFor: trustForwardedFor,
+		trustedProxyHops:  trustedProxyHops,
 	}
 }
 
 type contextKey string
+
+// clientIP returns the address of the client. X-Forwarded-For can be set by
+// anyone, so it is only used when the gateway runs behind trusted proxies,
+// and only the entry appended by the outermost of them is taken: every proxy
+// appends the address it received the request from, so the entries left of
+// it are whatever the client sent.
+func (m *Middleware) clientIP(r *http.Request) string {
+	if m.trustForwardedFor {
+		var entries []string
+		for _, xff := range r.Header.Values("X-Forwarded-For") {
+			for _, e := range strings.Split(xff, ",") {
+				entries = append(entries, strings.TrimSpace(e))
+			}
+		}
+		if len(entries) > 0 {
+			return entries[max(len(entries)-m.trustedProxyHops, 0)]
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/securemcp/securemcp-okta-gateway/config"
+	"github.com/securemcp/securemcp-okta-gateway/logging"
+	"github.com/securemcp/securemcp-okta-gateway/policy"
+)
+
+// Policy asks the evaluator of a proxy whether the request is allowed. It must
+// run after MCPBearerToken.
+func (m *Middleware) Policy(proxy *config.ProxyConfig, evaluator policy.Evaluator, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logging.FromContext(ctx).With(
+			slog.String("middleware", "Policy"),
+			slog.String("pattern", proxy.Pattern),
+		)
+
+		token := m.GetToken(ctx)
+		if token == nil {
+			log.Error("Failed to get token")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		decision, err := evaluator.Evaluate(ctx, &policy.Input{
+			UID:      token.UID,
+			Email:    token.Email,
+			Groups:   token.Groups,
+			Amr:      token.Amr,
+			ClientID: token.ClientID,
+			Scopes:   token.Scopes,
+			SourceIP: m.clientIP(r),
+			Method:   r.Method,
+			Path:     r.URL.Path,
+			Time:     time.Now(),
+		})
+		if err != nil {
+			log.Error("Failed to evaluate policy", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !decision.Allow {
+			log.Info("Policy denied request",
+				slog.String("rule", decision.Rule),
+				slog.String("uid", token.UID),
+				slog.String("client_id", token.ClientID),
+				slog.String("path", r.URL.Path),
+			)
+			forbidden(w, "The request is not allowed by the access policy")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
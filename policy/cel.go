@@ -0,0 +1,124 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/securemcp/securemcp-okta-gateway/config"
+)
+
+type celRule struct {
+	name    string
+	allow   bool
+	program cel.Program
+}
+
+// CELEvaluator evaluates rules written in the Common Expression Language.
+// Expressions can use these variables:
+//
+//	user.uid, user.email, user.email_domain, user.groups, user.amr
+//	client_id, scopes
+//	request.ip, request.method, request.path
+//	now (timestamp, e.g. now.getHours("Asia/Tokyo"))
+//
+// and the function inCIDR(ip, cidr).
+type CELEvaluator struct {
+	rules        []*celRule
+	defaultAllow bool
+}
+
+func NewCELEvaluator(config *config.PolicyConfig) (*CELEvaluator, error) {
+	env, err := cel.NewEnv(
+		cel.Variable("user", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("client_id", cel.StringType),
+		cel.Variable("scopes", cel.ListType(cel.StringType)),
+		cel.Variable("request", cel.MapType(cel.StringType, cel.StringType)),
+		cel.Variable("now", cel.TimestampType),
+		cel.Function("inCIDR",
+			cel.Overload("in_cidr_string_string",
+				[]*cel.Type{cel.StringType, cel.StringType},
+				cel.BoolType,
+				cel.BinaryBinding(inCIDR),
+			),
+		),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cel environment: %w", err)
+	}
+
+	e := &CELEvaluator{defaultAllow: config.Default == EffectAllow}
+	for _, r := range config.Rules {
+		ast, iss := env.Compile(r.Expression)
+		if iss.Err() != nil {
+			return nil, fmt.Errorf("failed to compile policy rule %s: %w", r.Name, iss.Err())
+		}
+		if ast.OutputType() != cel.BoolType {
+			return nil, fmt.Errorf("policy rule %s must evaluate to a bool", r.Name)
+		}
+		program, err := env.Program(ast)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build policy rule %s: %w", r.Name, err)
+		}
+		e.rules = append(e.rules, &celRule{
+			name:    r.Name,
+			allow:   r.Effect == EffectAllow,
+			program: program,
+		})
+	}
+	return e, nil
+}
+
+func (e *CELEvaluator) Evaluate(ctx context.Context, input *Input) (*Decision, error) {
+	activation := map[string]any{
+		"user": map[string]any{
+			"uid":          input.UID,
+			"email":        input.Email,
+			"email_domain": input.EmailDomain(),
+			"groups":       nonNil(input.Groups),
+			"amr":          nonNil(input.Amr),
+		},
+		"client_id": input.ClientID,
+		"scopes":    nonNil(input.Scopes),
+		"request": map[string]string{
+			"ip":     input.SourceIP,
+			"method": input.Method,
+			"path":   input.Path,
+		},
+		"now": input.Time,
+	}
+	for _, r := range e.rules {
+		out, _, err := r.program.ContextEval(ctx, activation)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate policy rule %s: %w", r.name, err)
+		}
+		if out == types.True {
+			return &Decision{Allow: r.allow, Rule: r.name}, nil
+		}
+	}
+	return &Decision{Allow: e.defaultAllow, Rule: DefaultRule}, nil
+}
+
+func inCIDR(ip, cidr ref.Val) ref.Val {
+	// An error rather than false, so that a forged address denies the request
+	// instead of skipping deny rules
+	addr, err := netip.ParseAddr(fmt.Sprint(ip.Value()))
+	if err != nil {
+		return types.NewErr("invalid ip: %q", ip.Value())
+	}
+	prefix, err := netip.ParsePrefix(fmt.Sprint(cidr.Value()))
+	if err != nil {
+		return types.NewErr("invalid cidr: %s", cidr.Value())
+	}
+	return types.Bool(prefix.Contains(addr.Unmap()))
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,56 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/securemcp/securemcp-okta-gateway/config"
+)
+
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// DefaultRule is reported as the matched rule when no rule matched.
+const DefaultRule = "default"
+
+// Evaluator decides whether a request to a proxy is allowed.
+type Evaluator interface {
+	Evaluate(ctx context.Context, input *Input) (*Decision, error)
+}
+
+// Input is everything a rule can match on.
+type Input struct {
+	UID      string
+	Email    string
+	Groups   []string
+	Amr      []string
+	ClientID string
+	Scopes   []string
+	SourceIP string
+	Method   string
+	Path     string
+	Time     time.Time
+}
+
+func (i *Input) EmailDomain() string {
+	_, domain, _ := strings.Cut(i.Email, "@")
+	return strings.ToLower(domain)
+}
+
+type Decision struct {
+	Allow bool
+	Rule  string // name of the matched rule, or DefaultRule
+}
+
+func NewEvaluator(config *config.PolicyConfig) (Evaluator, error) {
+	switch config.Engine {
+	case "cel":
+		return NewCELEvaluator(config)
+	default:
+		return nil, fmt.Errorf("unsupported policy engine: %s", config.Engine)
+	}
+}
@@ -0,0 +1,135 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"github.com/securemcp/securemcp-okta-gateway/provider"
+	"golang.org/x/oauth2"
+)
+
+type OIDCConfig struct {
+	Name         string
+	DisplayName  string
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURI  string
+	Scopes       []string
+}
+
+// OIDCProvider authenticates users against any standards-compliant OpenID
+// Connect issuer using the authorization code flow with PKCE.
+type OIDCProvider struct {
+	name        string
+	displayName string
+	oidcConfig  *oauth2.Config
+	verifier    *gooidc.IDTokenVerifier
+}
+
+func NewOIDCProvider(ctx context.Context, config *OIDCConfig) (*OIDCProvider, error) {
+	p, err := gooidc.NewProvider(ctx, config.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create oidc provider: %w", err)
+	}
+
+	oidcConfig := &oauth2.Config{
+		ClientID:     config.ClientID,
+		ClientSecret: config.ClientSecret,
+		Endpoint:     p.Endpoint(),
+		Scopes:       config.Scopes,
+		RedirectURL:  config.RedirectURI,
+	}
+
+	verifier := p.Verifier(&gooidc.Config{ClientID: config.ClientID})
+
+	displayName := config.DisplayName
+	if displayName == "" {
+		displayName = config.Name
+	}
+
+	return &OIDCProvider{
+		name:        config.Name,
+		displayName: displayName,
+		oidcConfig:  oidcConfig,
+		verifier:    verifier,
+	}, nil
+}
+
+func (p *OIDCProvider) Name() string {
+	return p.name
+}
+
+func (p *OIDCProvider) DisplayName() string {
+	return p.displayName
+}
+
+func (p *OIDCProvider) OAuth2Config() *oauth2.Config {
+	return p.oidcConfig
+}
+
+func (p *OIDCProvider) AuthCodeURL(state, nonce, codeChallenge, loginHint string) string {
+	opts := []oauth2.AuthCodeOption{
+		gooidc.Nonce(nonce),
+		oauth2.SetAuthURLParam("code_challenge", codeChallenge),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	}
+	if loginHint != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("login_hint", loginHint))
+	}
+	return p.oidcConfig.AuthCodeURL(state, opts...)
+}
+
+type idTokenClaims struct {
+	Sub               string   `json:"sub"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+	Groups            []string `json:"groups"`
+	Amr               []string `json:"amr"`
+	Nonce             string   `json:"nonce"`
+}
+
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*provider.Claims, error) {
+	oauth2Tok, err := p.oidcConfig.Exchange(
+		ctx,
+		code,
+		oauth2.SetAuthURLParam("code_verifier", codeVerifier),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code: %w", err)
+	}
+
+	rawIDToken, ok := oauth2Tok.Extra("id_token").(string)
+	if !ok {
+		return nil, fmt.Errorf("failed to get id_token")
+	}
+
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify id_token: %w", err)
+	}
+
+	var claims idTokenClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to parse claims: %w", err)
+	}
+
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("invalid nonce: %s", claims.Nonce)
+	}
+
+	return &provider.Claims{
+		Issuer:            idToken.Issuer,
+		Sub:               claims.Sub,
+		Email:             claims.Email,
+		EmailVerified:     claims.EmailVerified,
+		Name:              claims.Name,
+		PreferredUsername: claims.PreferredUsername,
+		Groups:            claims.Groups,
+		Amr:               claims.Amr,
+		Token:             oauth2Tok,
+	}, nil
+}
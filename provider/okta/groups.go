@@ -0,0 +1,67 @@
+package okta
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// groupsClient bounds the users API calls made while the user logs in.
+var groupsClient = &http.Client{Timeout: 10 * time.Second}
+
+type oktaGroup struct {
+	Profile struct {
+		Name string `json:"name"`
+	} `json:"profile"`
+}
+
+// userGroups reads the names of the groups a user belongs to from the Okta
+// users API, following pagination links.
+func (p *OktaProvider) userGroups(ctx context.Context, userID string) ([]string, error) {
+	groups := []string{}
+	next := p.oktaURL + "/api/v1/users/" + url.PathEscape(userID) + "/groups?limit=200"
+	for next != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, next, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("Authorization", "SSWS "+p.apiToken)
+		resp, err := groupsClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get groups: %w", err)
+		}
+		var page []oktaGroup
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to get groups: %s", resp.Status)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode groups: %w", err)
+		}
+		for _, g := range page {
+			groups = append(groups, g.Profile.Name)
+		}
+		next = nextLink(resp.Header.Values("Link"))
+	}
+	return groups, nil
+}
+
+// nextLink returns the rel="next" URL of RFC 8288 Link headers.
+func nextLink(links []string) string {
+	for _, header := range links {
+		for _, link := range strings.Split(header, ",") {
+			target, params, ok := strings.Cut(strings.TrimSpace(link), ";")
+			if !ok || !strings.Contains(params, `rel="next"`) {
+				continue
+			}
+			return strings.Trim(strings.TrimSpace(target), "<>")
+		}
+	}
+	return ""
+}
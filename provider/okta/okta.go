@@ -2,152 +2,80 @@ package okta
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/base64"
-	"fmt"
 
-	"github.com/coreos/go-oidc/v3/oidc"
-	"github.com/redis/go-redis/v9"
-	"github.com/securemcp/securemcp-okta-gateway/kvs"
-	"github.com/securemcp/securemcp-okta-gateway/util"
-	"golang.org/x/oauth2"
+	"github.com/securemcp/securemcp-okta-gateway/provider"
+	"github.com/securemcp/securemcp-okta-gateway/provider/oidc"
 )
 
 type OktaConfig struct {
+	Name             string
+	DisplayName      string
 	OktaURL          string
 	OktaClientID     string
 	OktaClientSecret string
 	OktaRedirectURI  string
+	// Custom authorization server ID, or "org" for the org authorization server
+	AuthorizationServerID string
+	Scopes                []string
+	// API token to look up groups when the groups claim is not in the ID token
+	OktaAPIToken string
 }
 
+// OktaProvider authenticates users against an Okta authorization server.
 type OktaProvider struct {
-	oktaStateKVS *kvs.KVS // key: sid, value: okta state
-	oktaNonceKVS *kvs.KVS // key: sid, value: okta nonce
-	oktaCodeKVS  *kvs.KVS // key: sid, value: okta code
-	oidcConfig   *oauth2.Config
-	verifier     *oidc.IDTokenVerifier
+	*oidc.OIDCProvider
+	oktaURL  string
+	apiToken string
 }
 
-func NewOktaProvider(ctx context.Context, config *OktaConfig, rdb *redis.Client) (*OktaProvider, error) {
-	oktaStateKVS := kvs.NewKVS(rdb, "okta_state", kvs.OAuthStateTTL)
-	oktaNonceKVS := kvs.NewKVS(rdb, "okta_nonce", kvs.OAuthStateTTL)
-	oktaCodeKVS := kvs.NewKVS(rdb, "okta_code", kvs.OAuthStateTTL)
-
-	provider, err := oidc.NewProvider(ctx, config.OktaURL+"/oauth2/default")
-	if err != nil {
-		return nil, fmt.Errorf("failed to create oidc provider: %w", err)
+// IssuerURL returns the issuer of the configured authorization server.
+func (c *OktaConfig) IssuerURL() string {
+	switch c.AuthorizationServerID {
+	case "":
+		return c.OktaURL + "/oauth2/default"
+	case "org":
+		return c.OktaURL
+	default:
+		return c.OktaURL + "/oauth2/" + c.AuthorizationServerID
 	}
+}
 
-	oidcConfig := &oauth2.Config{
+func NewOktaProvider(ctx context.Context, config *OktaConfig) (*OktaProvider, error) {
+	scopes := config.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile", "email"}
+	}
+	p, err := oidc.NewOIDCProvider(ctx, &oidc.OIDCConfig{
+		Name:         config.Name,
+		DisplayName:  config.DisplayName,
+		IssuerURL:    config.IssuerURL(),
 		ClientID:     config.OktaClientID,
 		ClientSecret: config.OktaClientSecret,
-		Endpoint:     provider.Endpoint(),
-		Scopes:       []string{"openid", "profile", "email"},
-		RedirectURL:  config.OktaRedirectURI,
+		RedirectURI:  config.OktaRedirectURI,
+		Scopes:       scopes,
+	})
+	if err != nil {
+		return nil, err
 	}
-
-	verifier := provider.Verifier(&oidc.Config{ClientID: config.OktaClientID})
-
 	return &OktaProvider{
-		oktaStateKVS: oktaStateKVS,
-		oktaNonceKVS: oktaNonceKVS,
-		oktaCodeKVS:  oktaCodeKVS,
-		oidcConfig:   oidcConfig,
-		verifier:     verifier,
+		OIDCProvider: p,
+		oktaURL:      config.OktaURL,
+		apiToken:     config.OktaAPIToken,
 	}, nil
 }
 
-func (p *OktaProvider) GetAuthCodeURL(ctx context.Context, sid string) (string, error) {
-	state := util.RandString(16)
-	nonce := util.RandString(16)
-	codeVerifier := util.RandString(96)
-	hashedCodeVerifier := sha256.Sum256([]byte(codeVerifier))
-	codeChallenge := base64.RawURLEncoding.EncodeToString(hashedCodeVerifier[:])
-
-	if err := p.oktaStateKVS.Set(ctx, sid, state); err != nil {
-		return "", err
-	}
-
-	if err := p.oktaNonceKVS.Set(ctx, sid, nonce); err != nil {
-		return "", err
-	}
-
-	if err := p.oktaCodeKVS.Set(ctx, sid, codeVerifier); err != nil {
-		return "", err
-	}
-
-	return p.oidcConfig.AuthCodeURL(state,
-		oidc.Nonce(nonce),
-		oauth2.SetAuthURLParam("code_challenge", codeChallenge),
-		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
-	), nil
-}
-
-type OktaClaims struct {
-	Sub               string   `json:"sub"`
-	Name              string   `json:"name"`
-	Email             string   `json:"email"`
-	Ver               int      `json:"ver"`
-	Iss               string   `json:"iss"`
-	Aud               string   `json:"aud"`
-	Iat               int      `json:"iat"`
-	Exp               int      `json:"exp"`
-	Jti               string   `json:"jti"`
-	Amr               []string `json:"amr"`
-	Idp               string   `json:"idp"`
-	Nonce             string   `json:"nonce"`
-	PreferredUsername string   `json:"preferred_username"`
-	AuthTime          int      `json:"auth_time"`
-	AtHash            string   `json:"at_hash"`
-}
-
-func (p *OktaProvider) Callback(ctx context.Context, sid, state, code string) (*OktaClaims, error) {
-	savedState, err := p.oktaStateKVS.GetDel(ctx, sid)
+func (p *OktaProvider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*provider.Claims, error) {
+	claims, err := p.OIDCProvider.Exchange(ctx, code, codeVerifier, nonce)
 	if err != nil {
 		return nil, err
 	}
-
-	codeVerifier, err := p.oktaCodeKVS.GetDel(ctx, sid)
-	if err != nil {
-		return nil, err
-	}
-
-	if savedState != state {
-		return nil, fmt.Errorf("invalid state: %s", savedState)
+	if len(claims.Groups) > 0 || p.apiToken == "" {
+		return claims, nil
 	}
-
-	oauth2Tok, err := p.oidcConfig.Exchange(
-		ctx,
-		code,
-		oauth2.SetAuthURLParam("code_verifier", codeVerifier),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to exchange code: %w", err)
-	}
-
-	rawIDToken, ok := oauth2Tok.Extra("id_token").(string)
-	if !ok {
-		return nil, fmt.Errorf("failed to get id_token")
-	}
-
-	idToken, err := p.verifier.Verify(ctx, rawIDToken)
-	if err != nil {
-		return nil, fmt.Errorf("failed to verify id_token: %w", err)
-	}
-
-	var claims OktaClaims
-	if err := idToken.Claims(&claims); err != nil {
-		return nil, fmt.Errorf("failed to parse claims: %w", err)
-	}
-
-	nonce, err := p.oktaNonceKVS.GetDel(ctx, sid)
+	groups, err := p.userGroups(ctx, claims.Sub)
 	if err != nil {
 		return nil, err
 	}
-
-	if claims.Nonce != nonce {
-		return nil, fmt.Errorf("invalid nonce: %s", claims.Nonce)
-	}
-
-	return &claims, nil
+	claims.Groups = groups
+	return claims, nil
 }
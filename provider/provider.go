@@ -0,0 +1,35 @@
+package provider
+
+import (
+	"context"
+
+	"golang.org/x/oauth2"
+)
+
+// Provider is an upstream OpenID Connect identity provider that
+// authenticates users for the gateway. Login state is kept by the Registry.
+type Provider interface {
+	Name() string
+	DisplayName() string
+	AuthCodeURL(state, nonce, codeChallenge, loginHint string) string
+	Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Claims, error)
+	// OAuth2Config is the client used to refresh the tokens of the user
+	OAuth2Config() *oauth2.Config
+}
+
+// Claims are the identity claims of an authenticated user, normalized across
+// providers.
+type Claims struct {
+	UID               string   `json:"uid"`
+	Provider          string   `json:"provider"`
+	Issuer            string   `json:"iss"`
+	Sub               string   `json:"sub"`
+	Email             string   `json:"email,omitempty"`
+	EmailVerified     bool     `json:"email_verified,omitempty"`
+	Name              string   `json:"name,omitempty"`
+	PreferredUsername string   `json:"preferred_username,omitempty"`
+	Groups            []string `json:"groups,omitempty"`
+	Amr               []string `json:"amr,omitempty"`
+	// Tokens issued to the gateway at login
+	Token *oauth2.Token `json:"-"`
+}
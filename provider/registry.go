@@ -0,0 +1,162 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/securemcp/securemcp-okta-gateway/kvs"
+	"github.com/securemcp/securemcp-okta-gateway/util"
+)
+
+// Registry holds every configured identity provider, picks the one a user
+// logs in with and keeps the login state of the flow in progress.
+type Registry struct {
+	providers     []Provider
+	byName        map[string]Provider
+	byDomain      map[string]Provider
+	domains       map[string][]string // key: provider name
+	loginStateKVS *kvs.KVS            // key: sid, value: login state
+}
+
+// Purposes of a login, returned by Callback so that the gateway can tell
+// the flows apart.
+const (
+	PurposeAuthorize = "authorize" // an OAuth client is authorized
+	PurposeConnect   = "connect"   // a user connects an account, see connections
+)
+
+type loginState struct {
+	Provider     string `json:"provider"`
+	Purpose      string `json:"purpose"`
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+}
+
+func NewRegistry(rdb *redis.Client) *Registry {
+	return &Registry{
+		byName:        map[string]Provider{},
+		byDomain:      map[string]Provider{},
+		domains:       map[string][]string{},
+		loginStateKVS: kvs.NewKVS(rdb, "login_state", kvs.OAuthStateTTL),
+	}
+}
+
+// Register adds a provider. Users whose email is in one of domains are sent
+// to it without being asked. The first provider registered is the primary
+// one, its users keep the plain subject as uid.
+func (r *Registry) Register(p Provider, domains []string) error {
+	if _, ok := r.byName[p.Name()]; ok {
+		return fmt.Errorf("duplicate identity provider: %s", p.Name())
+	}
+	for _, d := range domains {
+		d = strings.ToLower(d)
+		if other, ok := r.byDomain[d]; ok {
+			return fmt.Errorf("domain %s is already served by identity provider %s", d, other.Name())
+		}
+		r.byDomain[d] = p
+		r.domains[p.Name()] = append(r.domains[p.Name()], d)
+	}
+	r.providers = append(r.providers, p)
+	r.byName[p.Name()] = p
+	return nil
+}
+
+func (r *Registry) Providers() []Provider {
+	return r.providers
+}
+
+// Discover performs home-realm discovery: an explicit idp hint wins, then
+// the domain of the login hint. With a single provider there is nothing to
+// choose. ok is false when the user has to pick a provider.
+func (r *Registry) Discover(idpHint, loginHint string) (Provider, bool) {
+	if idpHint != "" {
+		p, ok := r.byName[idpHint]
+		return p, ok
+	}
+	if _, domain, found := strings.Cut(loginHint, "@"); found {
+		if p, ok := r.byDomain[strings.ToLower(domain)]; ok {
+			return p, true
+		}
+	}
+	if len(r.providers) == 1 {
+		return r.providers[0], true
+	}
+	return nil, false
+}
+
+// ProviderOf returns the provider the user with uid logs in with, see Callback.
+func (r *Registry) ProviderOf(uid string) Provider {
+	if name, _, found := strings.Cut(uid, ":"); found {
+		if p, ok := r.byName[name]; ok && p != r.providers[0] {
+			return p
+		}
+	}
+	return r.providers[0]
+}
+
+func (r *Registry) GetAuthCodeURL(ctx context.Context, sid string, p Provider, loginHint, purpose string) (string, error) {
+	state := &loginState{
+		Provider:     p.Name(),
+		Purpose:      purpose,
+		State:        util.RandString(16),
+		Nonce:        util.RandString(16),
+		CodeVerifier: util.RandString(96),
+	}
+	stateJSON, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+	if err := r.loginStateKVS.Set(ctx, sid, stateJSON); err != nil {
+		return "", err
+	}
+	return p.AuthCodeURL(state.State, state.Nonce, util.S256(state.CodeVerifier), loginHint), nil
+}
+
+// Callback completes the login started by GetAuthCodeURL with the provider
+// recorded in the login state of the session, and returns the purpose the
+// login was started for.
+func (r *Registry) Callback(ctx context.Context, sid, state, code string) (*Claims, string, error) {
+	stateJSON, err := r.loginStateKVS.GetDel(ctx, sid)
+	if err != nil {
+		return nil, "", err
+	}
+	var saved loginState
+	if err := json.Unmarshal([]byte(stateJSON), &saved); err != nil {
+		return nil, "", err
+	}
+	if saved.State != state {
+		return nil, "", fmt.Errorf("invalid state: %s", state)
+	}
+	p, ok := r.byName[saved.Provider]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown identity provider: %s", saved.Provider)
+	}
+
+	claims, err := p.Exchange(ctx, code, saved.CodeVerifier, saved.Nonce)
+	if err != nil {
+		return nil, "", err
+	}
+	claims.Provider = p.Name()
+	claims.UID = claims.Sub
+	if !claims.EmailVerified {
+		claims.Email = ""
+	}
+	// Subjects and groups are only unique per issuer, and a secondary
+	// provider must not speak for the email domains of another one
+	if p != r.providers[0] {
+		claims.UID = p.Name() + ":" + claims.Sub
+		for i, g := range claims.Groups {
+			claims.Groups[i] = p.Name() + ":" + g
+		}
+		_, domain, _ := strings.Cut(claims.Email, "@")
+		if domains := r.domains[p.Name()]; len(domains) > 0 && !slices.Contains(domains, strings.ToLower(domain)) {
+			claims.Email = ""
+		}
+	}
+	return claims, saved.Purpose, nil
+}
@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"sync/atomic"
+
+	"github.com/securemcp/securemcp-okta-gateway/config"
+)
+
+// upstream is one replica of the backend of a proxy.
+type upstream struct {
+	url     *url.URL
+	proxy   *httputil.ReverseProxy
+	active  atomic.Int64 // requests in flight through this gateway
+	healthy atomic.Bool  // result of the health probes, true without probes
+	breaker *circuitBreaker
+}
+
+func (u *upstream) available() bool {
+	return u.healthy.Load() && u.breaker.ready()
+}
+
+type balancer struct {
+	strategy  string
+	upstreams []*upstream
+	next      atomic.Uint64
+}
+
+// pick returns an available upstream, or nil when every upstream is
+// unhealthy or has an open circuit.
+func (b *balancer) pick() *upstream {
+	candidates := make([]*upstream, 0, len(b.upstreams))
+	for _, u := range b.upstreams {
+		if u.available() {
+			candidates = append(candidates, u)
+		}
+	}
+	for len(candidates) > 0 {
+		i := 0
+		switch b.strategy {
+		case config.LoadBalancingLeastConnections:
+			// Start at a rotating offset so that ties are spread evenly
+			offset := int(b.next.Add(1))
+			for j := range candidates {
+				k := (offset + j) % len(candidates)
+				if j == 0 || candidates[k].active.Load() < candidates[i].active.Load() {
+					i = k
+				}
+			}
+		default:
+			i = int((b.next.Add(1) - 1) % uint64(len(candidates)))
+		}
+		// Another request may have taken the trial of a half-open circuit
+		if candidates[i].breaker.acquire() {
+			return candidates[i]
+		}
+		candidates = slices.Delete(candidates, i, i+1)
+	}
+	return nil
+}
+
+// find returns the upstream with the URL if it is available.
+func (b *balancer) find(targetURL string) *upstream {
+	for _, u := range b.upstreams {
+		if u.url.String() == targetURL && u.healthy.Load() && u.breaker.acquire() {
+			return u
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/securemcp/securemcp-okta-gateway/auth"
+	"github.com/securemcp/securemcp-okta-gateway/logging"
+	"github.com/securemcp/securemcp-okta-gateway/tokenstore"
+)
+
+// forwardConnections passes the tokens of the accounts the user connected on
+// to the backend. Users who have not connected an account yet get a link to
+// connect it.
+func (p *ProxyService) forwardConnections(w http.ResponseWriter, r *http.Request) bool {
+	ctx := r.Context()
+	log := logging.FromContext(ctx).With(
+		slog.String("proxy", p.config.Pattern),
+		slog.String("request_id", ctx.Value(logging.RequestIDKey).(string)),
+	)
+	token := p.middleware.GetToken(ctx)
+	if token == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	for _, c := range p.config.Connections {
+		connToken, err := p.connections.Token(ctx, c.Name, token.UID)
+		if errors.Is(err, tokenstore.ErrNotFound) {
+			log.Info("Connection required", "uid", token.UID, "connection", c.Name)
+			jsonErr, err := p.connectionRequired(ctx, c.Name, token)
+			if err != nil {
+				log.Error("Failed to create connect url", "connection", c.Name, "error", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return false
+			}
+			ids, batch := requestIDs(r)
+			writeJSONRPCError(w, http.StatusForbidden, ids, batch, jsonErr)
+			return false
+		}
+		if err != nil {
+			log.Error("Failed to get connection token", "uid", token.UID, "connection", c.Name, "error", err)
+			writeJSONRPCErrors(w, http.StatusBadGateway, []json.RawMessage{json.RawMessage("null")}, false, jsonrpcUnavailable, "Bad gateway: failed to authorize to the upstream server")
+			return false
+		}
+		value := connToken.AccessToken
+		if c.Header == "Authorization" {
+			value = "Bearer " + value
+		}
+		r.Header.Set(c.Header, value)
+	}
+	return true
+}
+
+// connectionRequired is the error telling the user where to connect an account.
+func (p *ProxyService) connectionRequired(ctx context.Context, name string, token *auth.TokenRecord) (jsonrpcError, error) {
+	connectURL, err := p.connections.ConnectURL(ctx, name, token.UID, token.Email)
+	if err != nil {
+		return jsonrpcError{}, err
+	}
+	return jsonrpcError{
+		Code:    jsonrpcForbidden,
+		Message: "Connect your " + p.connections.DisplayName(name) + " account to use this server: " + connectURL,
+		Data: map[string]string{
+			"connection":  name,
+			"connect_url": connectURL,
+		},
+	}, nil
+}
+
+// upstreamUnauthorized replaces the 401 of a backend with its own
+// authorization, whose challenge the MCP client cannot answer, with a link
+// to authorize the gateway again.
+func (p *ProxyService) upstreamUnauthorized(resp *http.Response) error {
+	ctx := resp.Request.Context()
+	log := logging.FromContext(ctx).With(
+		slog.String("proxy", p.config.Pattern),
+		slog.String("request_id", ctx.Value(logging.RequestIDKey).(string)),
+	)
+	token := p.middleware.GetToken(ctx)
+	if token == nil {
+		return nil
+	}
+	name := p.config.UpstreamAuth.Connection
+	log.Info("Upstream rejected token", "uid", token.UID, "connection", name)
+	if err := p.connections.Disconnect(ctx, name, token.UID); err != nil {
+		return err
+	}
+	jsonErr, err := p.connectionRequired(ctx, name, token)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(jsonrpcErrorResponse{
+		JSONRPC: "2.0",
+		ID:      json.RawMessage("null"),
+		Error:   jsonErr,
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	resp.StatusCode = http.StatusForbidden
+	resp.Status = "403 Forbidden"
+	resp.Header = http.Header{}
+	resp.Header.Set("Content-Type", "application/json")
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	resp.ContentLength = int64(len(body))
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return nil
+}
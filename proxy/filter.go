@@ -0,0 +1,247 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/securemcp/securemcp-okta-gateway/auth"
+	"github.com/securemcp/securemcp-okta-gateway/config"
+	"github.com/securemcp/securemcp-okta-gateway/logging"
+)
+
+type listRequestsKey struct{}
+
+// listMethods maps the MCP list methods to the result member holding the
+// entries and the entry member the rules match on.
+var listMethods = map[string]struct {
+	key   string
+	field string
+	rules func(*config.MCPConfig) []*config.MCPRule
+}{
+	"tools/list":     {"tools", "name", func(c *config.MCPConfig) []*config.MCPRule { return c.Tools }},
+	"resources/list": {"resources", "uri", func(c *config.MCPConfig) []*config.MCPRule { return c.Resources }},
+	"prompts/list":   {"prompts", "name", func(c *config.MCPConfig) []*config.MCPRule { return c.Prompts }},
+}
+
+// withListRequests remembers the ids of the list requests among messages so
+// that their responses can be filtered.
+func withListRequests(r *http.Request, messages []jsonrpcMessage) *http.Request {
+	lists := map[string]string{}
+	for _, m := range messages {
+		if _, ok := listMethods[m.method()]; ok {
+			lists[string(bytes.TrimSpace(m.id()))] = m.method()
+		}
+	}
+	if len(lists) == 0 {
+		return r
+	}
+	// The body must stay readable to rewrite it
+	r.Header.Del("Accept-Encoding")
+	return r.WithContext(context.WithValue(r.Context(), listRequestsKey{}, lists))
+}
+
+func listRequests(ctx context.Context) map[string]string {
+	lists, _ := ctx.Value(listRequestsKey{}).(map[string]string)
+	return lists
+}
+
+// filterResponse removes the tools, resources and prompts the caller is not
+// permitted to use from list responses, in JSON bodies and SSE streams.
+func (p *ProxyService) filterResponse(resp *http.Response) error {
+	ctx := resp.Request.Context()
+	lists := listRequests(ctx)
+	if lists == nil {
+		return nil
+	}
+	token := p.middleware.GetToken(ctx)
+	if token == nil {
+		return errors.New("no token to filter the response")
+	}
+	if resp.Header.Get("Content-Encoding") != "" {
+		return errors.New("cannot filter an encoded response")
+	}
+	filter := &listFilter{mcp: p.config.MCP, lists: lists, token: token}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if filtered, ok := filter.rewrite(body); ok {
+			body = filtered
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+		resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	case "text/event-stream":
+		upstream := resp.Body
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(filter.rewriteEvents(ctx, upstream, pw))
+		}()
+		resp.Body = &filteredBody{PipeReader: pr, upstream: upstream}
+		resp.ContentLength = -1
+		resp.Header.Del("Content-Length")
+	}
+	return nil
+}
+
+type listFilter struct {
+	mcp   *config.MCPConfig
+	lists map[string]string
+	token *auth.TokenRecord
+}
+
+// rewrite filters the list responses in a JSON-RPC message or batch. It
+// reports false when nothing had to be changed.
+func (f *listFilter) rewrite(data []byte) ([]byte, bool) {
+	messages, batch, err := parseMessages(data)
+	if err != nil {
+		return nil, false
+	}
+	changed := false
+	for _, m := range messages {
+		if f.filterResult(m) {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil, false
+	}
+	var out []byte
+	if batch {
+		out, err = json.Marshal(messages)
+	} else {
+		out, err = json.Marshal(messages[0])
+	}
+	if err != nil {
+		return nil, false
+	}
+	return out, true
+}
+
+func (f *listFilter) filterResult(m jsonrpcMessage) bool {
+	if m == nil {
+		return false
+	}
+	method, ok := f.lists[string(bytes.TrimSpace(m.id()))]
+	if !ok {
+		return false
+	}
+	list := listMethods[method]
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal(m["result"], &result); err != nil {
+		return false
+	}
+	var entries []json.RawMessage
+	if err := json.Unmarshal(result[list.key], &entries); err != nil {
+		return false
+	}
+	rules := list.rules(f.mcp)
+	permittedEntries := make([]json.RawMessage, 0, len(entries))
+	for _, e := range entries {
+		var entry jsonrpcMessage
+		if err := json.Unmarshal(e, &entry); err != nil {
+			continue
+		}
+		var name string
+		if err := json.Unmarshal(entry[list.field], &name); err != nil {
+			continue
+		}
+		if permitted(f.mcp, config.Match(rules, name), f.token) {
+			permittedEntries = append(permittedEntries, e)
+		}
+	}
+	if len(permittedEntries) == len(entries) {
+		return false
+	}
+	filtered, err := json.Marshal(permittedEntries)
+	if err != nil {
+		return false
+	}
+	result[list.key] = filtered
+	if m["result"], err = json.Marshal(result); err != nil {
+		return false
+	}
+	return true
+}
+
+// rewriteEvents copies an SSE stream event by event, replacing the data of
+// events that carry a list response.
+func (f *listFilter) rewriteEvents(ctx context.Context, src io.Reader, dst io.Writer) error {
+	log := logging.FromContext(ctx)
+	reader := bufio.NewReader(src)
+	var event []string
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			event = append(event, line)
+			if strings.TrimRight(line, "\r\n") == "" {
+				if _, err := io.WriteString(dst, f.rewriteEvent(log, event)); err != nil {
+					return err
+				}
+				event = event[:0]
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				_, err = io.WriteString(dst, strings.Join(event, ""))
+			}
+			return err
+		}
+	}
+}
+
+func (f *listFilter) rewriteEvent(log *slog.Logger, event []string) string {
+	var data []string
+	for _, line := range event {
+		if value, ok := strings.CutPrefix(strings.TrimRight(line, "\r\n"), "data:"); ok {
+			data = append(data, strings.TrimPrefix(value, " "))
+		}
+	}
+	if len(data) == 0 {
+		return strings.Join(event, "")
+	}
+	filtered, ok := f.rewrite([]byte(strings.Join(data, "\n")))
+	if !ok {
+		return strings.Join(event, "")
+	}
+	log.Debug("Filtered list response in event stream")
+	var b strings.Builder
+	written := false
+	for _, line := range event {
+		if !strings.HasPrefix(line, "data:") {
+			b.WriteString(line)
+			continue
+		}
+		if !written {
+			b.WriteString("data: " + string(filtered) + "\n")
+			written = true
+		}
+	}
+	return b.String()
+}
+
+// filteredBody closes the upstream body with the pipe so that the goroutine
+// rewriting the stream ends when the client goes away.
+type filteredBody struct {
+	*io.PipeReader
+	upstream io.ReadCloser
+}
+
+func (b *filteredBody) Close() error {
+	b.PipeReader.Close()
+	return b.upstream.Close()
+}
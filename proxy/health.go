@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/securemcp/securemcp-okta-gateway/config"
+	"github.com/securemcp/securemcp-okta-gateway/logging"
+)
+
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half_open"
+)
+
+// circuitBreaker is nil when the proxy has no circuit breaker configured.
+type circuitBreaker struct {
+	config *config.CircuitBreakerConfig
+
+	mu       sync.Mutex
+	failures int
+	open     bool
+	openedAt time.Time // also the time of the last trial request
+}
+
+func (b *circuitBreaker) state() string {
+	if b == nil {
+		return circuitClosed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case !b.open:
+		return circuitClosed
+	case time.Since(b.openedAt) >= b.config.Cooldown:
+		return circuitHalfOpen
+	default:
+		return circuitOpen
+	}
+}
+
+func (b *circuitBreaker) ready() bool {
+	return b.state() != circuitOpen
+}
+
+// acquire reports whether a request may be sent. A half-open circuit lets a
+// single trial request through: checking the cooldown and restarting it
+// happen under one lock, so concurrent requests cannot all pass as trials.
+func (b *circuitBreaker) acquire() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.open {
+		return true
+	}
+	if time.Since(b.openedAt) < b.config.Cooldown {
+		return false
+	}
+	b.openedAt = time.Now()
+	return true
+}
+
+// record reports whether the result opened or closed the circuit.
+func (b *circuitBreaker) record(success bool) (opened, closed bool) {
+	if b == nil {
+		return false, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if success {
+		closed = b.open
+		b.failures = 0
+		b.open = false
+		return false, closed
+	}
+	b.failures++
+	if b.open {
+		b.openedAt = time.Now()
+		return false, false
+	}
+	if b.failures >= b.config.Failures {
+		b.open = true
+		b.openedAt = time.Now()
+		return true, false
+	}
+	return false, false
+}
+
+func (p *ProxyService) recordResult(ctx context.Context, u *upstream, success bool) {
+	opened, closed := u.breaker.record(success)
+	log := logging.FromContext(ctx).With(
+		slog.String("proxy", p.config.Pattern),
+		slog.String("upstream", u.url.String()),
+	)
+	if opened {
+		log.Warn("Circuit opened", "cooldown", p.config.CircuitBreaker.Cooldown)
+	}
+	if closed {
+		log.Info("Circuit closed")
+	}
+}
+
+// Start probes the health of every target until ctx is done.
+func (p *ProxyService) Start(ctx context.Context) {
+	if p.config.HealthCheck == nil {
+		return
+	}
+	for _, u := range p.balancer.upstreams {
+		go p.probe(ctx, u)
+	}
+}
+
+func (p *ProxyService) probe(ctx context.Context, u *upstream) {
+	hc := p.config.HealthCheck
+	log := logging.FromContext(ctx).With(
+		slog.String("proxy", p.config.Pattern),
+		slog.String("upstream", u.url.String()),
+	)
+	client := &http.Client{Timeout: hc.Timeout}
+	target := u.url.JoinPath(hc.Path).String()
+
+	ticker := time.NewTicker(hc.Interval)
+	defer ticker.Stop()
+	successes, failures := 0, 0
+	for {
+		err := probeOnce(ctx, client, target)
+		if err == nil {
+			successes, failures = successes+1, 0
+			if !u.healthy.Load() && successes >= hc.HealthyThreshold {
+				u.healthy.Store(true)
+				log.Info("Upstream is healthy")
+			}
+		} else {
+			successes, failures = 0, failures+1
+			if u.healthy.Load() && failures >= hc.UnhealthyThreshold {
+				u.healthy.Store(false)
+				log.Warn("Upstream is unhealthy", "error", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func probeOnce(ctx context.Context, client *http.Client, target string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// UpstreamStatus is reported by /readyz.
+type UpstreamStatus struct {
+	URL            string `json:"url"`
+	Healthy        bool   `json:"healthy"`
+	Circuit        string `json:"circuit"`
+	ActiveRequests int64  `json:"active_requests"`
+}
+
+// Status reports every target of the proxy and whether any can take requests.
+func (p *ProxyService) Status() ([]*UpstreamStatus, bool) {
+	statuses := make([]*UpstreamStatus, 0, len(p.balancer.upstreams))
+	available := false
+	for _, u := range p.balancer.upstreams {
+		statuses = append(statuses, &UpstreamStatus{
+			URL:            u.url.String(),
+			Healthy:        u.healthy.Load(),
+			Circuit:        u.breaker.state(),
+			ActiveRequests: u.active.Load(),
+		})
+		if u.available() {
+			available = true
+		}
+	}
+	return statuses, available
+}
+
+func (p *ProxyService) Pattern() string {
+	return p.config.Pattern
+}
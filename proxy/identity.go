@@ -0,0 +1,36 @@
+package proxy
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/securemcp/securemcp-okta-gateway/logging"
+)
+
+// forwardIdentity replaces the caller's access token with a signed identity
+// assertion so that the backend never sees a token it could replay.
+func (p *ProxyService) forwardIdentity(w http.ResponseWriter, r *http.Request) bool {
+	ctx := r.Context()
+	cfg := p.config.IdentityAssertion
+	token := p.middleware.GetToken(ctx)
+	if token == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	assertion, err := p.auth.SignIdentityAssertion(token, cfg.Audience, cfg.Claims, cfg.TTL)
+	if err != nil {
+		log := logging.FromContext(ctx).With(
+			slog.String("proxy", p.config.Pattern),
+			slog.String("request_id", ctx.Value(logging.RequestIDKey).(string)),
+		)
+		log.Error("Failed to sign identity assertion", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return false
+	}
+	r.Header.Del("Authorization")
+	if cfg.Header == "Authorization" {
+		assertion = "Bearer " + assertion
+	}
+	r.Header.Set(cfg.Header, assertion)
+	return true
+}
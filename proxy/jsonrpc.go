@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// JSON-RPC 2.0 error codes
+const (
+	jsonrpcParseError    = -32700
+	jsonrpcInvalidParams = -32602
+	// Implementation defined server errors
+	jsonrpcForbidden   = -32001
+	jsonrpcUnavailable = -32002
+)
+
+// jsonrpcMessage is a JSON-RPC 2.0 request, notification or response.
+// Members are looked up by their exact name: encoding/json matches struct
+// fields case-insensitively, which would let a "Name" member hide "name" from
+// the gateway while the backend still sees it.
+type jsonrpcMessage map[string]json.RawMessage
+
+func (m jsonrpcMessage) method() string {
+	var method string
+	_ = json.Unmarshal(m["method"], &method)
+	return method
+}
+
+func (m jsonrpcMessage) id() json.RawMessage {
+	if id, ok := m["id"]; ok {
+		return id
+	}
+	return json.RawMessage("null")
+}
+
+// stringParam returns a string member of the params object.
+func (m jsonrpcMessage) stringParam(name string) (string, bool) {
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(m["params"], &params); err != nil {
+		return "", false
+	}
+	var value string
+	if err := json.Unmarshal(params[name], &value); err != nil {
+		return "", false
+	}
+	return value, true
+}
+
+// parseMessages parses a single message or a batch.
+func parseMessages(body []byte) ([]jsonrpcMessage, bool, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []jsonrpcMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			return nil, true, err
+		}
+		if len(batch) == 0 {
+			return nil, true, errors.New("empty batch")
+		}
+		return batch, true, nil
+	}
+	var message jsonrpcMessage
+	if err := json.Unmarshal(body, &message); err != nil {
+		return nil, false, err
+	}
+	if message == nil {
+		return nil, false, errors.New("message must be an object")
+	}
+	return []jsonrpcMessage{message}, false, nil
+}
+
+type jsonrpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+type jsonrpcErrorResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Error   jsonrpcError    `json:"error"`
+}
+
+// writeJSONRPCErrors answers every id with the error. A batch gets an array.
+func writeJSONRPCErrors(w http.ResponseWriter, status int, ids []json.RawMessage, batch bool, code int, message string) {
+	writeJSONRPCError(w, status, ids, batch, jsonrpcError{Code: code, Message: message})
+}
+
+func writeJSONRPCError(w http.ResponseWriter, status int, ids []json.RawMessage, batch bool, jsonErr jsonrpcError) {
+	responses := make([]jsonrpcErrorResponse, 0, len(ids))
+	for _, id := range ids {
+		responses = append(responses, jsonrpcErrorResponse{
+			JSONRPC: "2.0",
+			ID:      id,
+			Error:   jsonErr,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if batch {
+		_ = json.NewEncoder(w).Encode(responses)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(responses[0])
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/securemcp/securemcp-okta-gateway/auth"
+	"github.com/securemcp/securemcp-okta-gateway/config"
+	"github.com/securemcp/securemcp-okta-gateway/logging"
+)
+
+// maxMessageSize limits the JSON-RPC request bodies the gateway inspects.
+const maxMessageSize = 10 << 20
+
+// mcpTarget returns the rules and the name a gated MCP request is checked
+// against: the tool or prompt name, or the resource URI.
+func mcpTarget(mcp *config.MCPConfig, message jsonrpcMessage) (rules []*config.MCPRule, param string, ok bool) {
+	switch message.method() {
+	case "tools/call":
+		return mcp.Tools, "name", true
+	case "resources/read", "resources/subscribe", "resources/unsubscribe":
+		return mcp.Resources, "uri", true
+	case "prompts/get":
+		return mcp.Prompts, "name", true
+	default:
+		return nil, "", false
+	}
+}
+
+// permitted reports whether the user of the token may use a name matched by
+// rule, nil when no rule matched.
+func permitted(mcp *config.MCPConfig, rule *config.MCPRule, token *auth.TokenRecord) bool {
+	if rule == nil {
+		return mcp.Default != "deny"
+	}
+	hasScope := func(scopes []string) bool {
+		return slices.ContainsFunc(scopes, func(s string) bool { return slices.Contains(token.Scopes, s) })
+	}
+	if token.InGroups(rule.DeniedGroups) || hasScope(rule.DeniedScopes) {
+		return false
+	}
+	if len(rule.AllowedGroups) == 0 && len(rule.AllowedScopes) == 0 {
+		return true
+	}
+	return token.InGroups(rule.AllowedGroups) || hasScope(rule.AllowedScopes)
+}
+
+// authorizeMessages checks the MCP calls in a POST body against the rules of
+// the proxy and answers denied calls with a JSON-RPC error. A batch with a
+// denied call is rejected as a whole. It reports whether the request may be
+// forwarded; the body is restored for the backend and list requests are
+// remembered for filterResponse.
+func (p *ProxyService) authorizeMessages(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	ctx := r.Context()
+	log := logging.FromContext(ctx).With(
+		slog.String("proxy", p.config.Pattern),
+		slog.String("request_id", ctx.Value(logging.RequestIDKey).(string)),
+	)
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMessageSize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+			return nil, false
+		}
+		log.Error("Failed to read request body", "error", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return nil, false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	messages, batch, err := parseMessages(body)
+	if err != nil {
+		log.Info("Failed to parse JSON-RPC message", "error", err)
+		writeJSONRPCErrors(w, http.StatusOK, []json.RawMessage{json.RawMessage("null")}, false, jsonrpcParseError, "Parse error")
+		return nil, false
+	}
+
+	token := p.middleware.GetToken(ctx)
+	if token == nil {
+		log.Error("Failed to get token")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	for _, message := range messages {
+		rules, param, ok := mcpTarget(p.config.MCP, message)
+		if !ok {
+			continue
+		}
+		code, errMessage := 0, ""
+		name, ok := message.stringParam(param)
+		if !ok {
+			code, errMessage = jsonrpcInvalidParams, fmt.Sprintf("Invalid params: %s is required", param)
+		} else if !permitted(p.config.MCP, config.Match(rules, name), token) {
+			log.Info("Denied MCP request",
+				slog.String("method", message.method()),
+				slog.String(param, name),
+				slog.String("uid", token.UID),
+				slog.String("client_id", token.ClientID),
+			)
+			code, errMessage = jsonrpcForbidden, fmt.Sprintf("Forbidden: %s is not allowed", name)
+		}
+		if code == 0 {
+			continue
+		}
+		ids := []json.RawMessage{message.id()}
+		if batch {
+			ids = ids[:0]
+			for _, m := range messages {
+				if _, ok := m["id"]; ok {
+					ids = append(ids, m.id())
+				}
+			}
+		}
+		writeJSONRPCErrors(w, http.StatusOK, ids, batch, code, errMessage)
+		return nil, false
+	}
+	return withListRequests(r, messages), true
+}
@@ -1,30 +1,194 @@
 package proxy
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httputil"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/redis/go-redis/v9"
+	"github.com/securemcp/securemcp-okta-gateway/auth"
 	"github.com/securemcp/securemcp-okta-gateway/config"
+	"github.com/securemcp/securemcp-okta-gateway/connections"
+	"github.com/securemcp/securemcp-okta-gateway/kvs"
+	"github.com/securemcp/securemcp-okta-gateway/logging"
 	"github.com/securemcp/securemcp-okta-gateway/middleware"
+	"github.com/securemcp/securemcp-okta-gateway/tokenstore"
 )
 
+// ClientIDHeader tells the backend which MCP client the caller is using.
+const ClientIDHeader = "X-MCP-Client-Id"
+
 type ProxyService struct {
-	proxy *httputil.ReverseProxy
+	auth        *auth.Auth
+	tokens      *tokenstore.Store
+	connections *connections.Manager
+	balancer    *balancer
+	config      *config.ProxyConfig
+	middleware  *middleware.Middleware
+	sessionKVS  *kvs.KVS
 }
 
-func NewProxyService(config *config.ProxyConfig, middleware *middleware.Middleware) *ProxyService {
-	proxy := httputil.NewSingleHostReverseProxy(config.TargetURL)
-	originalDirector := proxy.Director
-	proxy.Director = func(req *http.Request) {
-		originalDirector(req)
-		trimPrefix(req, config.Pattern)
+func NewProxyService(config *config.ProxyConfig, auth *auth.Auth, tokens *tokenstore.Store, connections *connections.Manager, middleware *middleware.Middleware, rdb *redis.Client) *ProxyService {
+	p := &ProxyService{
+		auth:        auth,
+		tokens:      tokens,
+		connections: connections,
+		balancer:    &balancer{strategy: config.LoadBalancing},
+		config:      config,
+		middleware:  middleware,
+		sessionKVS:  kvs.NewKVS(rdb, "mcp_session", kvs.MCPSessionTTL),
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = config.ResponseHeaderTimeout
+	for _, target := range config.TargetURLs {
+		proxy := httputil.NewSingleHostReverseProxy(target)
+		originalDirector := proxy.Director
+		proxy.Director = func(req *http.Request) {
+			originalDirector(req)
+			trimPrefix(req, config.Pattern)
+			req.Header.Del(ClientIDHeader)
+			if clientID := middleware.GetClientID(req.Context()); clientID != "" {
+				req.Header.Set(ClientIDHeader, clientID)
+			}
+		}
+		proxy.Transport = transport
+		// Flush every write so that SSE events and streamed responses are not held back
+		proxy.FlushInterval = -1
+		proxy.ModifyResponse = p.modifyResponse
+		proxy.ErrorHandler = p.errorHandler
+		u := &upstream{url: target, proxy: proxy}
+		u.healthy.Store(true)
+		if config.CircuitBreaker != nil {
+			u.breaker = &circuitBreaker{config: config.CircuitBreaker}
+		}
+		p.balancer.upstreams = append(p.balancer.upstreams, u)
 	}
-	return &ProxyService{proxy: proxy}
+	return p
 }
 
 func (p *ProxyService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	p.proxy.ServeHTTP(w, r)
+	if p.config.MCP != nil && r.Method == http.MethodPost {
+		var ok bool
+		if r, ok = p.authorizeMessages(w, r); !ok {
+			return
+		}
+	}
+	if p.config.IdentityAssertion != nil && !p.forwardIdentity(w, r) {
+		return
+	}
+	if p.config.UpstreamToken != nil && !p.forwardUpstreamToken(w, r) {
+		return
+	}
+	if len(p.config.Connections) > 0 && !p.forwardConnections(w, r) {
+		return
+	}
+	u := p.upstream(r)
+	if u == nil {
+		p.unavailable(w, r)
+		return
+	}
+	u.active.Add(1)
+	defer u.active.Add(-1)
+	u.proxy.ServeHTTP(w, r.WithContext(withUpstream(r.Context(), u)))
+}
+
+func (p *ProxyService) modifyResponse(resp *http.Response) error {
+	if u, ok := resp.Request.Context().Value(upstreamKey{}).(*upstream); ok {
+		p.recordResult(resp.Request.Context(), u, resp.StatusCode < http.StatusInternalServerError)
+	}
+	if p.config.UpstreamAuth != nil && resp.StatusCode == http.StatusUnauthorized {
+		return p.upstreamUnauthorized(resp)
+	}
+	p.trackSession(resp)
+	if p.config.MCP != nil {
+		if err := p.filterResponse(resp); err != nil {
+			return err
+		}
+	}
+	if isEventStream(resp.Header) {
+		ctx := resp.Request.Context()
+		log := logging.FromContext(ctx).With(
+			slog.String("proxy", p.config.Pattern),
+			slog.String("request_id", ctx.Value(logging.RequestIDKey).(string)),
+		)
+		resp.Body = newStreamBody(ctx, log, resp.Body, p.config.HeartbeatInterval, p.config.IdleTimeout)
+	}
+	return nil
+}
+
+// errorHandler does not answer clients that already went away; their
+// canceled request also cancels the upstream request.
+func (p *ProxyService) errorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	ctx := r.Context()
+	log := logging.FromContext(ctx).With(
+		slog.String("proxy", p.config.Pattern),
+		slog.String("request_id", ctx.Value(logging.RequestIDKey).(string)),
+	)
+	u, ok := ctx.Value(upstreamKey{}).(*upstream)
+	if ok {
+		log = log.With(slog.String("upstream", u.url.String()))
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		log.Info("Client disconnected")
+		return
+	}
+	if ok {
+		p.recordResult(ctx, u, false)
+	}
+	log.Error("Failed to proxy request", "error", err)
+	writeJSONRPCErrors(w, http.StatusBadGateway, []json.RawMessage{json.RawMessage("null")}, false, jsonrpcUnavailable, "Bad gateway: upstream request failed")
+}
+
+// unavailable fails fast with a JSON-RPC error for every request in the body
+// when no upstream can take the request.
+func (p *ProxyService) unavailable(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logging.FromContext(ctx).With(
+		slog.String("proxy", p.config.Pattern),
+		slog.String("request_id", ctx.Value(logging.RequestIDKey).(string)),
+	)
+	log.Warn("No upstream available")
+
+	ids, batch := requestIDs(r)
+	retryAfter := time.Second
+	if p.config.CircuitBreaker != nil {
+		retryAfter = p.config.CircuitBreaker.Cooldown
+	} else if p.config.HealthCheck != nil {
+		retryAfter = p.config.HealthCheck.Interval
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+	writeJSONRPCErrors(w, http.StatusServiceUnavailable, ids, batch, jsonrpcUnavailable, "Service unavailable: no healthy upstream")
+}
+
+// requestIDs returns the ids of the requests in the body so that every one of
+// them can be answered with an error.
+func requestIDs(r *http.Request) ([]json.RawMessage, bool) {
+	ids := []json.RawMessage{}
+	batch := false
+	if r.Method == http.MethodPost {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxMessageSize))
+		if err == nil {
+			var messages []jsonrpcMessage
+			if messages, batch, err = parseMessages(body); err == nil {
+				for _, m := range messages {
+					if _, ok := m["id"]; ok && m.method() != "" {
+						ids = append(ids, m.id())
+					}
+				}
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return []json.RawMessage{json.RawMessage("null")}, false
+	}
+	return ids, batch
 }
 
 func trimPrefix(req *http.Request, pattern string) {
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/securemcp/securemcp-okta-gateway/logging"
+)
+
+// SessionIDHeader carries the MCP session created by a stateful backend.
+const SessionIDHeader = "Mcp-Session-Id"
+
+type upstreamKey struct{}
+
+// upstream routes requests of an MCP session to the replica that created it,
+// so every gateway replica agrees, and balances the others.
+func (p *ProxyService) upstream(r *http.Request) *upstream {
+	sessionID := r.Header.Get(SessionIDHeader)
+	if sessionID == "" || len(p.balancer.upstreams) == 1 {
+		return p.balancer.pick()
+	}
+	ctx := r.Context()
+	targetURL, err := p.sessionKVS.Get(ctx, p.sessionKey(sessionID))
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			logging.FromContext(ctx).Error("Failed to get MCP session", "error", err)
+		}
+		return p.balancer.pick()
+	}
+	if u := p.balancer.find(targetURL); u != nil {
+		return u
+	}
+	return p.balancer.pick()
+}
+
+// trackSession pins new sessions to the replica that answered and forgets
+// sessions that were terminated or that the replica no longer knows.
+func (p *ProxyService) trackSession(resp *http.Response) {
+	if len(p.balancer.upstreams) == 1 {
+		return
+	}
+	ctx := resp.Request.Context()
+	log := logging.FromContext(ctx).With(
+		slog.String("proxy", p.config.Pattern),
+	)
+	u, ok := ctx.Value(upstreamKey{}).(*upstream)
+	if !ok {
+		return
+	}
+
+	requested := resp.Request.Header.Get(SessionIDHeader)
+	switch {
+	case requested != "" && (resp.StatusCode == http.StatusNotFound ||
+		resp.Request.Method == http.MethodDelete && resp.StatusCode < 300):
+		if err := p.sessionKVS.Del(ctx, p.sessionKey(requested)); err != nil {
+			log.Error("Failed to delete MCP session", "error", err)
+		}
+	case resp.Header.Get(SessionIDHeader) != "" && resp.Header.Get(SessionIDHeader) != requested:
+		sessionID := resp.Header.Get(SessionIDHeader)
+		if err := p.sessionKVS.Set(ctx, p.sessionKey(sessionID), u.url.String()); err != nil {
+			log.Error("Failed to store MCP session", "error", err)
+			return
+		}
+		log.Info("Pinned MCP session", "upstream", u.url.String())
+	}
+}
+
+func (p *ProxyService) sessionKey(sessionID string) string {
+	return p.config.Pattern + sessionID
+}
+
+func withUpstream(ctx context.Context, u *upstream) context.Context {
+	return context.WithValue(ctx, upstreamKey{}, u)
+}
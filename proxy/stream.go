@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const heartbeat = ": ping\n"
+
+func isEventStream(h http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+// streamBody forwards an SSE stream line by line. It sends a heartbeat
+// comment when the stream has been quiet for the heartbeat interval, ends the
+// stream after the idle timeout without upstream data, stops when the client
+// goes away and logs a summary when it is closed.
+type streamBody struct {
+	ctx      context.Context
+	log      *slog.Logger
+	upstream io.ReadCloser
+
+	heartbeatInterval time.Duration
+	idleTimeout       time.Duration
+
+	lines chan string
+	err   error // upstream error, read after lines is closed
+	done  chan struct{}
+	once  sync.Once
+
+	pending   string
+	lastBlank bool
+	start     time.Time
+	lastData  time.Time // heartbeats do not count
+	events    int
+	reason    string
+}
+
+func newStreamBody(ctx context.Context, log *slog.Logger, upstream io.ReadCloser, heartbeatInterval, idleTimeout time.Duration) *streamBody {
+	b := &streamBody{
+		ctx:               ctx,
+		log:               log,
+		upstream:          upstream,
+		heartbeatInterval: heartbeatInterval,
+		idleTimeout:       idleTimeout,
+		lines:             make(chan string),
+		done:              make(chan struct{}),
+		lastBlank:         true,
+		start:             time.Now(),
+		lastData:          time.Now(),
+	}
+	go b.readLines()
+	return b
+}
+
+func (b *streamBody) readLines() {
+	defer close(b.lines)
+	reader := bufio.NewReader(b.upstream)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			select {
+			case b.lines <- line:
+			case <-b.done:
+				return
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				b.err = err
+			}
+			return
+		}
+	}
+}
+
+func (b *streamBody) Read(p []byte) (int, error) {
+	if b.pending == "" {
+		if err := b.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, b.pending)
+	b.pending = b.pending[n:]
+	return n, nil
+}
+
+// next waits for the next upstream line or a heartbeat.
+func (b *streamBody) next() error {
+	var heartbeatC, idleC <-chan time.Time
+	if b.heartbeatInterval > 0 {
+		t := time.NewTimer(b.heartbeatInterval)
+		defer t.Stop()
+		heartbeatC = t.C
+	}
+	if b.idleTimeout > 0 {
+		t := time.NewTimer(b.idleTimeout - time.Since(b.lastData))
+		defer t.Stop()
+		idleC = t.C
+	}
+	for {
+		select {
+		case line, ok := <-b.lines:
+			if !ok {
+				if b.err != nil {
+					b.reason = "upstream error"
+					return b.err
+				}
+				b.reason = "upstream closed"
+				return io.EOF
+			}
+			blank := strings.TrimRight(line, "\r\n") == ""
+			if blank && !b.lastBlank {
+				b.events++
+			}
+			b.lastBlank = blank
+			b.lastData = time.Now()
+			b.pending = line
+			return nil
+		case <-heartbeatC:
+			// Lines are forwarded whole and SSE parsers ignore comments,
+			// so a heartbeat can go between any two lines
+			b.pending = heartbeat
+			return nil
+		case <-idleC:
+			b.reason = "idle timeout"
+			return io.EOF
+		case <-b.ctx.Done():
+			b.reason = "client disconnected"
+			return b.ctx.Err()
+		}
+	}
+}
+
+func (b *streamBody) Close() error {
+	var err error
+	b.once.Do(func() {
+		close(b.done)
+		err = b.upstream.Close()
+		reason := b.reason
+		if reason == "" {
+			reason = "client disconnected"
+		}
+		b.log.Info("Stream closed",
+			slog.Duration("duration", time.Since(b.start)),
+			slog.Int("events", b.events),
+			slog.String("reason", reason),
+		)
+	})
+	return err
+}
@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/securemcp/securemcp-okta-gateway/logging"
+	"github.com/securemcp/securemcp-okta-gateway/tokenstore"
+)
+
+// forwardUpstreamToken passes the access token the identity provider issued
+// to the user on to the backend, refreshed when it is about to expire.
+func (p *ProxyService) forwardUpstreamToken(w http.ResponseWriter, r *http.Request) bool {
+	ctx := r.Context()
+	log := logging.FromContext(ctx).With(
+		slog.String("proxy", p.config.Pattern),
+		slog.String("request_id", ctx.Value(logging.RequestIDKey).(string)),
+	)
+	token := p.middleware.GetToken(ctx)
+	if token == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	upstreamToken, err := p.tokens.Token(ctx, tokenstore.LoginKey(token.UID))
+	if errors.Is(err, tokenstore.ErrNotFound) {
+		log.Info("No upstream token", "uid", token.UID)
+		ids, batch := requestIDs(r)
+		writeJSONRPCErrors(w, http.StatusForbidden, ids, batch, jsonrpcForbidden, "Forbidden: sign in again to let the server act on your behalf")
+		return false
+	}
+	if err != nil {
+		log.Error("Failed to get upstream token", "uid", token.UID, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return false
+	}
+	value := upstreamToken.AccessToken
+	if p.config.UpstreamToken.Header == "Authorization" {
+		value = "Bearer " + value
+	}
+	r.Header.Set(p.config.UpstreamToken.Header, value)
+	return true
+}
@@ -0,0 +1,276 @@
+package tokenstore
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/securemcp/securemcp-okta-gateway/kvs"
+	"github.com/securemcp/securemcp-okta-gateway/logging"
+	"golang.org/x/oauth2"
+)
+
+// Tokens are refreshed when they expire within refreshBefore, so that a
+// backend never receives a token that expires while it uses it.
+const refreshBefore = 5 * time.Minute
+
+// refreshLockTTL bounds how long a crashed replica can block refreshes.
+const refreshLockTTL = 30 * time.Second
+
+var ErrNotFound = errors.New("upstream token not found")
+
+// Store keeps upstream OAuth tokens of users encrypted in the kvs and
+// refreshes them with the OAuth client of the provider that issued them.
+type Store struct {
+	tokenKVS  *kvs.KVS // key: token key, value: encrypted record
+	lockKVS   *kvs.KVS // key: token key, value: refresh in progress
+	clientKVS *kvs.KVS // key: registration endpoint, value: encrypted client
+	aead      cipher.AEAD
+
+	mu      sync.RWMutex
+	configs map[string]*refreshConfig // key: provider name
+}
+
+type refreshConfig struct {
+	oauth2 *oauth2.Config
+	client *http.Client
+}
+
+type record struct {
+	Provider string        `json:"provider"`
+	Token    *oauth2.Token `json:"token"`
+}
+
+// NewStore creates a store encrypting tokens with AES-256-GCM under key, a
+// base64 encoded 32 byte key.
+func NewStore(rdb *redis.Client, key string) (*Store, error) {
+	k, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(k) != 32 {
+		return nil, fmt.Errorf("upstream token key must be 32 bytes encoded in base64")
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{
+		tokenKVS:  kvs.NewKVS(rdb, "upstream_token", kvs.ResourceAccessTokenTTL),
+		lockKVS:   kvs.NewKVS(rdb, "upstream_token_lock", refreshLockTTL),
+		clientKVS: kvs.NewKVS(rdb, "upstream_client", kvs.UpstreamClientTTL),
+		aead:      aead,
+		configs:   map[string]*refreshConfig{},
+	}, nil
+}
+
+// LoginKey is the key of the tokens issued when the user logged in.
+func LoginKey(uid string) string {
+	return "login:" + uid
+}
+
+// ConnectionKey is the key of the tokens of the account the user connected.
+func ConnectionKey(connection, uid string) string {
+	return "connection:" + connection + ":" + uid
+}
+
+// Register sets the OAuth client used to refresh tokens of provider. Refresh
+// requests are sent with client, nil uses http.DefaultClient.
+func (s *Store) Register(provider string, config *oauth2.Config, client *http.Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.configs[provider] = &refreshConfig{oauth2: config, client: client}
+}
+
+func (s *Store) Save(ctx context.Context, key, provider string, token *oauth2.Token) error {
+	recordJSON, err := json.Marshal(&record{Provider: provider, Token: token})
+	if err != nil {
+		return err
+	}
+	sealed, err := s.seal(key, recordJSON)
+	if err != nil {
+		return err
+	}
+	return s.tokenKVS.Set(ctx, key, sealed)
+}
+
+// SaveClient stores the credentials the gateway registered with at an
+// authorization server, unless another replica registered first. ok is false
+// when the credentials were not stored.
+func (s *Store) SaveClient(ctx context.Context, registrationEndpoint string, client []byte) (bool, error) {
+	sealed, err := s.seal(registrationEndpoint, client)
+	if err != nil {
+		return false, err
+	}
+	return s.clientKVS.SetNX(ctx, registrationEndpoint, sealed)
+}
+
+// Client returns the credentials stored by SaveClient, or ErrNotFound.
+func (s *Store) Client(ctx context.Context, registrationEndpoint string) ([]byte, error) {
+	value, err := s.clientKVS.Get(ctx, registrationEndpoint)
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.open(registrationEndpoint, value)
+}
+
+// DeleteClient forgets credentials the authorization server no longer accepts.
+func (s *Store) DeleteClient(ctx context.Context, registrationEndpoint string) error {
+	return s.clientKVS.Del(ctx, registrationEndpoint)
+}
+
+// seal encrypts plaintext. The key is authenticated so that a value cannot be
+// moved to another key, such as the token of another user.
+func (s *Store) seal(key string, plaintext []byte) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(s.aead.Seal(nonce, nonce, plaintext, []byte(key))), nil
+}
+
+// open decrypts a value sealed under key. Values encrypted with a previous
+// key are reported as ErrNotFound.
+func (s *Store) open(key, value string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(sealed) < s.aead.NonceSize() {
+		return nil, fmt.Errorf("malformed sealed value")
+	}
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, []byte(key))
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return plaintext, nil
+}
+
+func (s *Store) Delete(ctx context.Context, key string) error {
+	return s.tokenKVS.Del(ctx, key)
+}
+
+// Token returns a token that is valid for at least refreshBefore when
+// possible, refreshing it first. ErrNotFound means the user has to log in
+// again, because there is no token or the provider rejected the refresh.
+func (s *Store) Token(ctx context.Context, key string) (*oauth2.Token, error) {
+	log := logging.FromContext(ctx).With(
+		slog.String("tokenstore", "Token"),
+	)
+	saved, err := s.load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	token := saved.Token
+	if token.Expiry.IsZero() || time.Until(token.Expiry) > refreshBefore {
+		return token, nil
+	}
+	if token.RefreshToken == "" {
+		if token.Valid() {
+			return token, nil
+		}
+		return nil, ErrNotFound
+	}
+
+	// Only one replica refreshes, rotated refresh tokens can be used once
+	locked, err := s.lockKVS.SetNX(ctx, key, "1")
+	if err != nil {
+		return nil, err
+	}
+	if !locked {
+		return s.waitForRefresh(ctx, key, token)
+	}
+	defer func() {
+		if err := s.lockKVS.Del(context.WithoutCancel(ctx), key); err != nil {
+			log.Error("Failed to release refresh lock", "error", err)
+		}
+	}()
+
+	s.mu.RLock()
+	config, ok := s.configs[saved.Provider]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown provider: %s", saved.Provider)
+	}
+	refreshCtx := ctx
+	if config.client != nil {
+		refreshCtx = context.WithValue(ctx, oauth2.HTTPClient, config.client)
+	}
+	refreshed, err := config.oauth2.TokenSource(refreshCtx, &oauth2.Token{RefreshToken: token.RefreshToken}).Token()
+	if err != nil {
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_grant" {
+			log.Info("Upstream refresh token rejected", "provider", saved.Provider)
+			if err := s.Delete(ctx, key); err != nil {
+				return nil, err
+			}
+			return nil, ErrNotFound
+		}
+		if token.Valid() {
+			log.Warn("Failed to refresh upstream token, using current token", "provider", saved.Provider, "error", err)
+			return token, nil
+		}
+		return nil, fmt.Errorf("failed to refresh upstream token: %w", err)
+	}
+	if err := s.Save(ctx, key, saved.Provider, refreshed); err != nil {
+		return nil, err
+	}
+	log.Info("Refreshed upstream token", "provider", saved.Provider)
+	return refreshed, nil
+}
+
+// waitForRefresh returns the current token while it is still valid, and
+// otherwise waits for the refresh running elsewhere.
+func (s *Store) waitForRefresh(ctx context.Context, key string, token *oauth2.Token) (*oauth2.Token, error) {
+	for i := 0; !token.Valid(); i++ {
+		if i == 10 {
+			return nil, fmt.Errorf("timed out waiting for upstream token refresh")
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(300 * time.Millisecond):
+		}
+		saved, err := s.load(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		token = saved.Token
+	}
+	return token, nil
+}
+
+func (s *Store) load(ctx context.Context, key string) (*record, error) {
+	value, err := s.tokenKVS.Get(ctx, key)
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Tokens encrypted with a previous key are lost, users log in again
+	recordJSON, err := s.open(key, value)
+	if err != nil {
+		return nil, err
+	}
+	var saved record
+	if err := json.Unmarshal(recordJSON, &saved); err != nil {
+		return nil, err
+	}
+	if saved.Token == nil {
+		return nil, ErrNotFound
+	}
+	return &saved, nil
+}